@@ -4,8 +4,12 @@ package assembler
 
 import (
 	"bufio"
+	"cmp"
 	"fmt"
+	"maps"
+	"math"
 	"os"
+	"slices"
 
 	"github.com/gboncoffee/intergo"
 )
@@ -15,6 +19,16 @@ const (
 	TOKEN_INSTRUCTION
 	TOKEN_ARG
 	TOKEN_LITERAL
+	// Defined by .equ and .set, followed by an argument with it's value.
+	TOKEN_CONSTANT
+	// Defined by .text, .data and .bss, with the name of the section.
+	TOKEN_SECTION
+	// Defined by .align, .balign and .org, with the name of the directive,
+	// followed by an argument with it's value.
+	TOKEN_LOCATION
+	// Defined by .bits8, .bits16, .bits32, .bits64 and .space, with the
+	// name of the directive, followed by arguments with it's values.
+	TOKEN_DATA
 )
 
 // This variable is a workaround between circular imports: ideally, we would
@@ -23,8 +37,9 @@ const (
 // Please don't touch.
 var InterCtx *intergo.InterContext
 
-// Can be a label, an instruction, an argument or a literal. All directives are
-// resolved in the tokenizer stage.
+// Can be a label, an instruction, an argument, a literal, a constant, a
+// section, a location or data. All other directives are resolved in the
+// tokenizer stage.
 type Token struct {
 	File  *string
 	Value []byte
@@ -67,18 +82,52 @@ type Instruction struct {
 	Reserved uintptr
 }
 
-// If the token is not a label, uses the provided function to translate it.
-func TranslateArgument(arg string, labels map[string]uint64, translateArg func(string) (uint64, error)) (uint64, error) {
-	value, hasLabel := labels[arg]
-	if !hasLabel {
-		var err error
-		value, err = translateArg(arg)
-		if err != nil {
-			return 0, err
-		}
-	}
+// Sections of a program, changed with the .text, .data and .bss directives.
+const (
+	SECTION_TEXT = iota
+	SECTION_DATA
+	SECTION_BSS
+)
+
+// Options of ResolveProgram.
+type ResolveOptions struct {
+	// Address of programs without sections.
+	Base uint64
+	// Allows the .text, .data and .bss directives. The .text and .data
+	// sections start at TextBase and DataBase, and .bss follows .data.
+	Sections bool
+	TextBase uint64
+	DataBase uint64
+	// Functions that may be called in arguments as %name(expression), e.g.,
+	// the ones created by HiLo.
+	Functions map[string]func(uint64) uint64
+	// Called with the value of every instruction argument that isn't a
+	// label, e.g., to check that an immediate fits in the instruction.
+	CheckArgument func(arg string, value uint64) error
+	// Last address of the machine, which .align and .org cannot go past.
+	// Defaults to the last 32 bits address.
+	MaxAddress uint64
+	// Label where the execution starts, if it's defined. Empty starts at
+	// the start of the text.
+	Entry string
+}
 
-	return value, nil
+// A program resolved by ResolveProgram.
+type Program struct {
+	// Tokens of the .text and .data sections. Programs without sections
+	// only have text. .bss has no tokens, as it's only zeros.
+	Text     []ResolvedToken
+	Data     []ResolvedToken
+	TextBase uint64
+	DataBase uint64
+	// First address after the program (after .bss in programs with
+	// sections), where the heap may start.
+	End uint64
+	// Address of the Entry label of the options if it's defined, or the
+	// start of the text.
+	Entry uint64
+	// Tokens of the instructions, sorted by address.
+	DebuggerTokens []DebuggerToken
 }
 
 // "Resolve" tokens. The process callback can be used for basically anything,
@@ -86,16 +135,55 @@ func TranslateArgument(arg string, labels map[string]uint64, translateArg func(s
 // an argument when the architecture accepts them, and set something with the
 // Reserved field informing that the addressing mode of the instruction is XYZ.
 //
-// translateArg is passed directly to TranslateArgument.
+// translateArg translates arguments that aren't constants, labels or
+// expressions, e.g., registers and numbers.
 func ResolveTokens(tokens []Token, process func(*Instruction) error, translateArg func(string) (uint64, error)) ([]ResolvedToken, []DebuggerToken, error) {
+	program, err := ResolveProgram(tokens, ResolveOptions{}, process, translateArg)
+	if err != nil {
+		return nil, nil, err
+	}
+	return program.Text, program.DebuggerTokens, nil
+}
+
+// Same as ResolveTokens, but with options for backends with more features,
+// such as sections.
+func ResolveProgram(tokens []Token, options ResolveOptions, process func(*Instruction) error, translateArg func(string) (uint64, error)) (Program, error) {
+	if options.MaxAddress == 0 {
+		options.MaxAddress = math.MaxUint32
+	}
 	resolvedTokens := []ResolvedToken{}
 	labels := make(map[string]uint64)
 	reverseLabels := make(map[uint64]string)
-	address := uint64(0)
 
-	// We use this so we can process everything and only after translate
-	// the arguments.
-	arguments := make(map[uint64][]string)
+	program := Program{TextBase: options.Base, DataBase: options.DataBase}
+	hasSections := slices.ContainsFunc(tokens, func(t Token) bool {
+		return t.Type == TOKEN_SECTION
+	})
+	if hasSections {
+		program.TextBase = options.TextBase
+	}
+
+	// Current address of each section. Addresses in .bss are relative to
+	// it's start, which is only known after .data, so it's labels are kept
+	// apart until then.
+	section := SECTION_TEXT
+	starts := [3]uint64{program.TextBase, program.DataBase, 0}
+	addresses := starts
+	bssLabels := make(map[string]uint64)
+	// .bss is aligned to 8 bytes so any word fits, or more if .align is
+	// used inside it.
+	bssAlignment := uint64(8)
+
+	// We use these so we can process everything and only after translate
+	// the arguments. They have an element for each resolved token.
+	sections := []int{}
+	arguments := [][]string{}
+	// Constants may be defined again with .set, so every instruction (and
+	// bits directive) keeps the constants defined before it. The map is
+	// copied whenever a constant is defined, thus instructions may share the
+	// same map.
+	constants := make(map[string]uint64)
+	instructionConstants := []map[string]uint64{}
 
 	for i := 0; i < len(tokens); i++ {
 		token := &tokens[i]
@@ -103,19 +191,188 @@ func ResolveTokens(tokens []Token, process func(*Instruction) error, translateAr
 		// Token before instruction.
 		case TOKEN_ARG:
 			panic(InterCtx.Get("If you're reading this, there's a bug in the emulator. Please fill an issue at https://github.com/gboncoffee/egg reporting the bug with the Assembly you're trying to run and command line arguments you used to run EGG."))
+		case TOKEN_SECTION:
+			if !options.Sections {
+				return Program{}, fmt.Errorf(InterCtx.Get("%v:%v: Sections are not supported by the selected architecture"), *token.File, token.Line)
+			}
+			switch string(token.Value) {
+			case "text":
+				section = SECTION_TEXT
+			case "data":
+				section = SECTION_DATA
+			case "bss":
+				section = SECTION_BSS
+			}
 		case TOKEN_LABEL:
-			labels[string(token.Value)] = address
-			reverseLabels[address] = string(token.Value)
+			if section == SECTION_BSS {
+				bssLabels[string(token.Value)] = addresses[section]
+				break
+			}
+			labels[string(token.Value)] = addresses[section]
+			reverseLabels[addresses[section]] = string(token.Value)
+		case TOKEN_CONSTANT:
+			i++
+			if i >= len(tokens) || tokens[i].Type != TOKEN_ARG {
+				panic(InterCtx.Get("If you're reading this, there's a bug in the emulator. Please fill an issue at https://github.com/gboncoffee/egg reporting the bug with the Assembly you're trying to run and command line arguments you used to run EGG."))
+			}
+
+			// The value may use labels and constants defined before.
+			symbols := symbolTable{constants: constants, labels: labels, functions: options.Functions, translateArg: translateArg}
+			value, err := symbols.translate(string(tokens[i].Value))
+			if err != nil {
+				return Program{}, fmt.Errorf(InterCtx.Get("%v:%v: Error on constant translation: %v"), *token.File, token.Line, err)
+			}
+			constants = maps.Clone(constants)
+			constants[string(token.Value)] = value
+		case TOKEN_LOCATION:
+			i++
+			if i >= len(tokens) || tokens[i].Type != TOKEN_ARG {
+				panic(InterCtx.Get("If you're reading this, there's a bug in the emulator. Please fill an issue at https://github.com/gboncoffee/egg reporting the bug with the Assembly you're trying to run and command line arguments you used to run EGG."))
+			}
+
+			// As with constants, the value may use labels and constants
+			// defined before.
+			symbols := symbolTable{constants: constants, labels: labels, functions: options.Functions, translateArg: translateArg}
+			value, err := symbols.translate(string(tokens[i].Value))
+			if err != nil {
+				return Program{}, fmt.Errorf(InterCtx.Get("%v:%v: Error on argument translation: %v"), *token.File, token.Line, err)
+			}
+
+			// .align takes a power of two and .balign the number of bytes.
+			// .org is relative to the start of the section. The value is
+			// checked against the address space before computing the
+			// target, so it cannot overflow.
+			var target uint64
+			inside := true
+			switch string(token.Value) {
+			case "align", "balign":
+				boundary := value
+				if string(token.Value) == "align" {
+					boundary = 1 << value
+				}
+				if boundary == 0 || boundary&(boundary-1) != 0 {
+					return Program{}, fmt.Errorf(InterCtx.Get("%v:%v: Invalid alignment %v"), *token.File, token.Line, value)
+				}
+				inside = boundary <= options.MaxAddress
+				target = (addresses[section] + boundary - 1) &^ (boundary - 1)
+				if section == SECTION_BSS {
+					bssAlignment = max(bssAlignment, boundary)
+				}
+			case "org":
+				inside = value <= options.MaxAddress
+				target = starts[section] + value
+				if inside && target < addresses[section] {
+					return Program{}, fmt.Errorf(InterCtx.Get("%v:%v: .org cannot move backwards from 0x%x to 0x%x"), *token.File, token.Line, addresses[section], target)
+				}
+			}
+			if !inside || target > options.MaxAddress {
+				return Program{}, fmt.Errorf(InterCtx.Get("%v:%v: .%s %s goes outside of the address space"), *token.File, token.Line, token.Value, tokens[i].Value)
+			}
+
+			// The gap is filled with zeros.
+			if target > addresses[section] && section != SECTION_BSS {
+				resolvedTokens = append(resolvedTokens, ResolvedToken{
+					Line:    token.Line,
+					File:    token.File,
+					Type:    TOKEN_LITERAL,
+					Address: addresses[section],
+					Value:   make([]byte, target-addresses[section]),
+				})
+				sections = append(sections, section)
+				arguments = append(arguments, nil)
+				instructionConstants = append(instructionConstants, nil)
+			}
+			addresses[section] = target
+		case TOKEN_DATA:
+			directive := string(token.Value)
+			values := []string{}
+			i++
+			for i < len(tokens) && tokens[i].Type == TOKEN_ARG {
+				values = append(values, string(tokens[i].Value))
+				i++
+			}
+			i--
+
+			// Data values can't be registers, so only numbers are
+			// translated besides constants, labels and expressions.
+			symbols := symbolTable{constants: constants, labels: labels, functions: options.Functions, translateArg: translateNumber}
+
+			// As with .org, the size of .space may use labels and
+			// constants defined before.
+			if directive == "space" {
+				size, err := symbols.translate(values[0])
+				if err != nil {
+					return Program{}, fmt.Errorf(InterCtx.Get("%v:%v: Cannot create space: Cannot parse %v to number: %v"), *token.File, token.Line, values[0], err)
+				}
+				if size > options.MaxAddress-addresses[section] {
+					return Program{}, fmt.Errorf(InterCtx.Get("%v:%v: .%s %s goes outside of the address space"), *token.File, token.Line, directive, values[0])
+				}
+				if section != SECTION_BSS {
+					resolvedTokens = append(resolvedTokens, ResolvedToken{
+						Line:    token.Line,
+						File:    token.File,
+						Type:    TOKEN_LITERAL,
+						Address: addresses[section],
+						Value:   make([]byte, size),
+					})
+					sections = append(sections, section)
+					arguments = append(arguments, nil)
+					instructionConstants = append(instructionConstants, nil)
+				}
+				addresses[section] += size
+				break
+			}
+
+			size := map[string]int{"bits8": 1, "bits16": 2, "bits32": 4, "bits64": 8}[directive]
+			if section == SECTION_BSS {
+				// .bss has no tokens, so the values are checked now.
+				for _, value := range values {
+					if n, err := symbols.translate(value); err != nil || n != 0 {
+						return Program{}, fmt.Errorf(InterCtx.Get("%v:%v: .bss can only reserve space"), *token.File, token.Line)
+					}
+				}
+				addresses[section] += uint64(size * len(values))
+				break
+			}
+
+			// The values are translated with the arguments of the
+			// instructions, after all labels are known.
+			resolvedTokens = append(resolvedTokens, ResolvedToken{
+				Line:    token.Line,
+				File:    token.File,
+				Type:    TOKEN_LITERAL,
+				Address: addresses[section],
+				Value:   make([]byte, size*len(values)),
+			})
+			sections = append(sections, section)
+			arguments = append(arguments, values)
+			instructionConstants = append(instructionConstants, constants)
+			addresses[section] += uint64(size * len(values))
 		case TOKEN_LITERAL:
+			if section == SECTION_BSS {
+				if slices.ContainsFunc(token.Value, func(b byte) bool { return b != 0 }) {
+					return Program{}, fmt.Errorf(InterCtx.Get("%v:%v: .bss can only reserve space"), *token.File, token.Line)
+				}
+				addresses[section] += uint64(len(token.Value))
+				break
+			}
+
 			resolvedTokens = append(resolvedTokens, ResolvedToken{
 				Line:    token.Line,
 				File:    token.File,
 				Type:    TOKEN_LITERAL,
-				Address: address,
+				Address: addresses[section],
 				Value:   token.Value,
 			})
-			address += uint64(len(token.Value))
+			sections = append(sections, section)
+			arguments = append(arguments, nil)
+			instructionConstants = append(instructionConstants, nil)
+			addresses[section] += uint64(len(token.Value))
 		case TOKEN_INSTRUCTION:
+			if section == SECTION_BSS {
+				return Program{}, fmt.Errorf(InterCtx.Get("%v:%v: .bss can only reserve space"), *token.File, token.Line)
+			}
+
 			instruction := Instruction{
 				Line:     token.Line,
 				File:     token.File,
@@ -132,7 +389,7 @@ func ResolveTokens(tokens []Token, process func(*Instruction) error, translateAr
 			i--
 
 			if err := process(&instruction); err != nil {
-				return nil, nil, err
+				return Program{}, err
 			}
 
 			// Finally create a proper token and append it. The arguments are
@@ -143,55 +400,131 @@ func ResolveTokens(tokens []Token, process func(*Instruction) error, translateAr
 				File:     instruction.File,
 				Type:     TOKEN_INSTRUCTION,
 				Value:    []byte(instruction.Mnemonic),
-				Address:  address,
+				Address:  addresses[section],
 				Reserved: instruction.Reserved,
 			})
+			sections = append(sections, section)
+			arguments = append(arguments, instruction.Args)
+			instructionConstants = append(instructionConstants, constants)
+			addresses[section] += instruction.Size
+		}
+	}
+
+	// .bss starts after .data.
+	bssBase := (addresses[SECTION_DATA] + bssAlignment - 1) &^ (bssAlignment - 1)
+	for label, offset := range bssLabels {
+		labels[label] = bssBase + offset
+	}
+	program.End = addresses[SECTION_TEXT]
+	if hasSections {
+		program.End = bssBase + addresses[SECTION_BSS]
 
-			arguments[address] = instruction.Args
-			address += instruction.Size
+		// The padding before .bss only counts if there's a .bss, and empty
+		// data may be anywhere, even inside the text.
+		textEnd := addresses[SECTION_TEXT]
+		dataEnd := program.End
+		if addresses[SECTION_BSS] == 0 {
+			dataEnd = addresses[SECTION_DATA]
+		}
+		if program.DataBase < dataEnd && program.TextBase < dataEnd && program.DataBase < textEnd {
+			return Program{}, fmt.Errorf(InterCtx.Get("the text section (0x%x-0x%x) overlaps the data section (0x%x-0x%x)"), program.TextBase, textEnd, program.DataBase, dataEnd)
 		}
 	}
 
 	// Now that we have all labels, we can treat the arguments. We also create
 	// the debugger tokens.
-	debuggerTokens := []DebuggerToken{}
+	textDebuggerTokens := []DebuggerToken{}
+	dataDebuggerTokens := []DebuggerToken{}
 	for i := 0; i < len(resolvedTokens); i++ {
 		token := &resolvedTokens[i]
 		if token.Type == TOKEN_INSTRUCTION {
-			args, ok := arguments[token.Address]
-			if !ok {
-				panic(InterCtx.Get("If you're reading this, there's a bug in the emulator. Please fill an issue at https://github.com/gboncoffee/egg reporting the bug with the Assembly you're trying to run and command line arguments you used to run EGG."))
-			}
-
-			debuggerTokens = append(debuggerTokens, DebuggerToken{
+			args := arguments[i]
+			debuggerToken := DebuggerToken{
 				Line:        token.Line,
 				File:        token.File,
 				Instruction: string(token.Value),
 				Args:        args,
 				Address:     token.Address,
 				Label:       reverseLabels[token.Address],
-			})
+			}
+			if sections[i] == SECTION_TEXT {
+				textDebuggerTokens = append(textDebuggerTokens, debuggerToken)
+			} else {
+				dataDebuggerTokens = append(dataDebuggerTokens, debuggerToken)
+			}
 
+			symbols := symbolTable{
+				constants:    instructionConstants[i],
+				labels:       labels,
+				functions:    options.Functions,
+				translateArg: translateArg,
+			}
 			for _, arg := range args {
-				result, err := TranslateArgument(arg, labels, translateArg)
+				result, err := symbols.translate(arg)
+				if _, isLabel := labels[arg]; err == nil && !isLabel && options.CheckArgument != nil {
+					err = options.CheckArgument(arg, result)
+				}
 				if err != nil {
-					return nil, nil, fmt.Errorf(InterCtx.Get("%v:%v: Error on argument translation: %v"), *token.File, token.Line, err)
+					return Program{}, fmt.Errorf(InterCtx.Get("%v:%v: Error on argument translation: %v"), *token.File, token.Line, err)
 				}
 				token.Args = append(token.Args, result)
 			}
+		} else if values := arguments[i]; values != nil {
+			// Values of a bits directive, stored in little endian.
+			symbols := symbolTable{
+				constants:    instructionConstants[i],
+				labels:       labels,
+				functions:    options.Functions,
+				translateArg: translateNumber,
+			}
+			size := len(token.Value) / len(values)
+			for j, value := range values {
+				n, err := symbols.translate(value)
+				if err != nil {
+					return Program{}, fmt.Errorf(InterCtx.Get("%v:%v: Error on argument translation: %v"), *token.File, token.Line, err)
+				}
+				if !fits(n, size*8) {
+					return Program{}, fmt.Errorf(InterCtx.Get("%v:%v: Cannot convert %v to a %v bits number"), *token.File, token.Line, value, size*8)
+				}
+				for k := 0; k < size; k++ {
+					token.Value[j*size+k] = byte(n >> (8 * k))
+				}
+			}
+		}
+
+		if sections[i] == SECTION_TEXT {
+			program.Text = append(program.Text, *token)
+		} else {
+			program.Data = append(program.Data, *token)
 		}
 	}
+	// The debugger looks tokens up by address, and the data may be before the
+	// text.
+	program.DebuggerTokens = append(textDebuggerTokens, dataDebuggerTokens...)
+	slices.SortStableFunc(program.DebuggerTokens, func(a, b DebuggerToken) int {
+		return cmp.Compare(a.Address, b.Address)
+	})
 
-	return resolvedTokens, debuggerTokens, nil
+	program.Entry = program.TextBase
+	if address, ok := labels[options.Entry]; ok && options.Entry != "" {
+		program.Entry = address
+	}
+
+	return program, nil
 }
 
 // Tokenize recursively (as of .include directives) creates a Token array from
 // file names. I.e., it opens and reads the passed file, opening and reading
-// other files when reaching a .include.
+// other files when reaching a .include. Macros are expanded in place.
 func Tokenize(fileName string, tokens *[]Token) error {
 	// Private functions used here are defined in tokenizer.go for the sake
 	// of organization.
+	return tokenizeFile(fileName, tokens, &tokenizerState{
+		macros: make(map[string]*macro),
+	})
+}
 
+func tokenizeFile(fileName string, tokens *[]Token, state *tokenizerState) error {
 	file, err := os.Open(fileName)
 	if err != nil {
 		return fmt.Errorf(InterCtx.Get("couldn't open file: %v"), err)
@@ -211,7 +544,7 @@ func Tokenize(fileName string, tokens *[]Token) error {
 			return fmt.Errorf(InterCtx.Get("error reading file %v: %v"), fileName, err)
 		}
 
-		err = parseLine(&fileName, &line, i, tokens)
+		err = parseLine(&fileName, &line, i, tokens, state)
 		if err != nil {
 			return err
 		}
@@ -219,5 +552,30 @@ func Tokenize(fileName string, tokens *[]Token) error {
 		i++
 	}
 
+	// Macros must end in the same file they start.
+	if state.defining != nil {
+		m := state.defining
+		state.defining = nil
+		return fmt.Errorf(InterCtx.Get("%v:%v: Unterminated macro %v"), fileName, m.line, m.name)
+	}
+
 	return nil
 }
+
+// Returns the name of the file and of every file included by it with .include,
+// so they can be watched for changes. Files are found by tokenizing, thus
+// included files without any content are not returned. In case of errors,
+// returns the files found until the error.
+func SourceFiles(fileName string) ([]string, error) {
+	tokens := []Token{}
+	err := Tokenize(fileName, &tokens)
+
+	files := []string{fileName}
+	for _, token := range tokens {
+		if !slices.Contains(files, *token.File) {
+			files = append(files, *token.File)
+		}
+	}
+
+	return files, err
+}
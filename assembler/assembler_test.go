@@ -1,7 +1,13 @@
 package assembler
 
 import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"testing"
+
+	"github.com/gboncoffee/intergo"
 )
 
 func TestTokenizer(t *testing.T) {
@@ -17,3 +23,423 @@ func TestTokenizer(t *testing.T) {
 	// UPDATE (2024/08/22): in fact, as RISC-V already have tests, we don't need
 	// to test the tokenizer so badly.
 }
+
+func TestSourceFiles(t *testing.T) {
+	files, err := SourceFiles("tokenizer_test.asm")
+	if err != nil {
+		t.Fatalf("error finding source files: %v", err)
+	}
+
+	if !slices.Equal(files, []string{"tokenizer_test.asm", "included_tokenizer_test.asm"}) {
+		t.Fatalf("wrong source files: %v", files)
+	}
+}
+
+func TestConstants(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "constants.asm")
+	err := os.WriteFile(file, []byte(".set N, 3\nstart:\n.equ HERE, start\ni N\n.set N, 0x10\ni N, HERE\n"), 0644)
+	if err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+
+	var tokens []Token
+	err = Tokenize(file, &tokens)
+	if err != nil {
+		t.Fatalf("error tokenizing: %v", err)
+	}
+
+	resolved, _, err := ResolveTokens(tokens, func(i *Instruction) error {
+		i.Size = 1
+		return nil
+	}, func(arg string) (uint64, error) {
+		return strconv.ParseUint(arg, 0, 64)
+	})
+	if err != nil {
+		t.Fatalf("error resolving tokens: %v", err)
+	}
+
+	if len(resolved) != 2 || !slices.Equal(resolved[0].Args, []uint64{3}) || !slices.Equal(resolved[1].Args, []uint64{0x10, 0}) {
+		t.Fatalf("wrong resolved tokens: %v", resolved)
+	}
+}
+
+func TestMacros(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "macros.asm")
+	err := os.WriteFile(file, []byte(".macro m a, ab\nl\\@: i \\ab, \\a\n.endm\nm 1, 2\nm 3, 4\n"), 0644)
+	if err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+
+	var tokens []Token
+	err = Tokenize(file, &tokens)
+	if err != nil {
+		t.Fatalf("error tokenizing: %v", err)
+	}
+
+	values := []string{}
+	for _, token := range tokens {
+		values = append(values, string(token.Value))
+		if token.Line != 4 && token.Line != 5 {
+			t.Fatalf("token %s with line %v instead of the invocation line", token.Value, token.Line)
+		}
+	}
+	expected := []string{"l1", "i", "2", "1", "l2", "i", "4", "3"}
+	if !slices.Equal(values, expected) {
+		t.Fatalf("wrong tokens: %v (expected %v)", values, expected)
+	}
+}
+
+func TestExpressions(t *testing.T) {
+	symbols := symbolTable{
+		constants: map[string]uint64{"N": 4},
+		labels:    map[string]uint64{"start": 0x10, "end": 0x12345fff},
+		functions: HiLo(12),
+		translateArg: func(arg string) (uint64, error) {
+			return strconv.ParseUint(arg, 0, 64)
+		},
+	}
+
+	tests := []struct {
+		expr     string
+		expected uint64
+	}{
+		{"start+N", 0x14},
+		{"end - start", 0x12345fef},
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"1 << 4 | 3 & ~1", 0x12},
+		{"-7 / 2", uint64(0xfffffffffffffffd)},
+		{"-1 >> 60", uint64(0xffffffffffffffff)},
+		{"'a' + '\\n'", 'a' + '\n'},
+		{"%hi(end)", 0x12346},
+		{"%lo(end)", 0xfff},
+		{"(%hi(end) << 12) + (%lo(end) << 52 >> 52)", 0x12345fff},
+	}
+	for _, test := range tests {
+		value, err := symbols.translate(test.expr)
+		if err != nil || value != test.expected {
+			t.Errorf("wrong value of %v: 0x%x, %v (expected 0x%x)", test.expr, value, err, test.expected)
+		}
+	}
+
+	// Registers are understood by translateArg, but are not values.
+	InterCtx = &intergo.InterContext{}
+	InterCtx.Init()
+	symbols.translateArg = func(arg string) (uint64, error) {
+		if arg == "t0" {
+			return 5, nil
+		}
+		return strconv.ParseUint(arg, 0, 64)
+	}
+	if value, err := symbols.translate("t0 + 4"); err == nil {
+		t.Errorf("register accepted in expression with value 0x%x", value)
+	}
+}
+
+func TestSections(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "sections.asm")
+	err := os.WriteFile(file, []byte(".data\nmsg:\n#hi%00\n.bss\nbuf:\n.space 8\n.text\nmain:\ni msg, buf\n.data\nafter:\n#!\n"), 0644)
+	if err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+
+	var tokens []Token
+	err = Tokenize(file, &tokens)
+	if err != nil {
+		t.Fatalf("error tokenizing: %v", err)
+	}
+
+	options := ResolveOptions{Sections: true, TextBase: 0x100, DataBase: 0x200}
+	program, err := ResolveProgram(tokens, options, func(i *Instruction) error {
+		i.Size = 4
+		return nil
+	}, func(arg string) (uint64, error) {
+		return strconv.ParseUint(arg, 0, 64)
+	})
+	if err != nil {
+		t.Fatalf("error resolving tokens: %v", err)
+	}
+
+	// .bss follows .data aligned to 8 bytes, even with .data after it.
+	if len(program.Text) != 1 || !slices.Equal(program.Text[0].Args, []uint64{0x200, 0x208}) {
+		t.Fatalf("wrong text: %v", program.Text)
+	}
+	if len(program.Data) != 2 || program.Data[1].Address != 0x203 {
+		t.Fatalf("wrong data: %v", program.Data)
+	}
+	if program.Entry != 0x100 || program.End != 0x210 {
+		t.Fatalf("wrong entry 0x%x or end 0x%x", program.Entry, program.End)
+	}
+
+	// The error is translated, so the context must be set up.
+	InterCtx = &intergo.InterContext{}
+	InterCtx.Init()
+
+	// The data (0x10 bytes with .bss) may be before or after the text (4
+	// bytes), as long as they don't overlap.
+	layouts := []struct {
+		textBase uint64
+		dataBase uint64
+		overlaps bool
+	}{
+		{0x200, 0x100, false},
+		{0x110, 0x100, false},
+		{0x100, 0x104, false},
+		{0x108, 0x100, true},
+		{0x100, 0x102, true},
+	}
+	for _, layout := range layouts {
+		options := ResolveOptions{Sections: true, TextBase: layout.textBase, DataBase: layout.dataBase}
+		_, err = ResolveProgram(tokens, options, func(i *Instruction) error {
+			i.Size = 4
+			return nil
+		}, func(arg string) (uint64, error) {
+			return strconv.ParseUint(arg, 0, 64)
+		})
+		if (err != nil) != layout.overlaps {
+			t.Errorf("wrong overlap of text at 0x%x and data at 0x%x: %v", layout.textBase, layout.dataBase, err)
+		}
+	}
+
+	// An empty data section inside the text doesn't overlap it, and the
+	// debugger tokens are sorted by address even with the data before the
+	// text.
+	sources := []struct {
+		source   string
+		textBase uint64
+		dataBase uint64
+	}{
+		{".text\ni 1\ni 2\n", 0x100, 0x104},
+		{".text\ni 1\n.data\ni 2\n", 0x104, 0x100},
+	}
+	for _, source := range sources {
+		err = os.WriteFile(file, []byte(source.source), 0644)
+		if err != nil {
+			t.Fatalf("error writing file: %v", err)
+		}
+		tokens = nil
+		err = Tokenize(file, &tokens)
+		if err != nil {
+			t.Fatalf("error tokenizing: %v", err)
+		}
+		options := ResolveOptions{Sections: true, TextBase: source.textBase, DataBase: source.dataBase}
+		program, err := ResolveProgram(tokens, options, func(i *Instruction) error {
+			i.Size = 4
+			return nil
+		}, func(arg string) (uint64, error) {
+			return strconv.ParseUint(arg, 0, 64)
+		})
+		if err != nil {
+			t.Fatalf("error resolving %q: %v", source.source, err)
+		}
+		addresses := []uint64{}
+		for _, token := range program.DebuggerTokens {
+			addresses = append(addresses, token.Address)
+		}
+		if !slices.IsSorted(addresses) {
+			t.Errorf("debugger tokens of %q not sorted: %v", source.source, addresses)
+		}
+	}
+
+	options.Sections = false
+	_, err = ResolveProgram(tokens, options, func(i *Instruction) error {
+		i.Size = 4
+		return nil
+	}, func(arg string) (uint64, error) {
+		return strconv.ParseUint(arg, 0, 64)
+	})
+	if err == nil {
+		t.Fatalf("sections accepted without support")
+	}
+}
+
+func TestEntry(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "entry.asm")
+	err := os.WriteFile(file, []byte("i main\nmain:\ni\n_start:\ni\n"), 0644)
+	if err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+
+	var tokens []Token
+	err = Tokenize(file, &tokens)
+	if err != nil {
+		t.Fatalf("error tokenizing: %v", err)
+	}
+
+	// Labels other than the entry one, such as main, don't change it.
+	entries := map[string]uint64{"": 0, "_start": 8, "main": 4, "missing": 0}
+	for entry, address := range entries {
+		program, err := ResolveProgram(tokens, ResolveOptions{Entry: entry}, func(i *Instruction) error {
+			i.Size = 4
+			return nil
+		}, func(arg string) (uint64, error) {
+			return strconv.ParseUint(arg, 0, 64)
+		})
+		if err != nil {
+			t.Fatalf("error resolving tokens: %v", err)
+		}
+		if program.Entry != address {
+			t.Errorf("entry %q at 0x%x, expected 0x%x", entry, program.Entry, address)
+		}
+	}
+}
+
+func TestLocations(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "locations.asm")
+	err := os.WriteFile(file, []byte("#abc\n.align 2\na:\ni\n.balign 8\nb:\n.org 0x20\nc:\ni a, b, c\n"), 0644)
+	if err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+
+	var tokens []Token
+	err = Tokenize(file, &tokens)
+	if err != nil {
+		t.Fatalf("error tokenizing: %v", err)
+	}
+
+	resolved, _, err := ResolveTokens(tokens, func(i *Instruction) error {
+		i.Size = 4
+		return nil
+	}, func(arg string) (uint64, error) {
+		return strconv.ParseUint(arg, 0, 64)
+	})
+	if err != nil {
+		t.Fatalf("error resolving tokens: %v", err)
+	}
+
+	last := resolved[len(resolved)-1]
+	if last.Address != 0x20 || !slices.Equal(last.Args, []uint64{4, 8, 0x20}) {
+		t.Fatalf("wrong resolved tokens: %v", resolved)
+	}
+	// The gaps are filled with zeros, so the tokens are contiguous.
+	next := uint64(0)
+	for _, token := range resolved {
+		if token.Address != next {
+			t.Fatalf("gap before token at 0x%x", token.Address)
+		}
+		next = token.Address + uint64(len(token.Value))
+		if token.Type == TOKEN_INSTRUCTION {
+			next = token.Address + 4
+		}
+	}
+}
+
+func TestData(t *testing.T) {
+	InterCtx = &intergo.InterContext{}
+	InterCtx.Init()
+
+	file := filepath.Join(t.TempDir(), "data.asm")
+	err := os.WriteFile(file, []byte(".equ SIZE, 4\n.bits32 later SIZE*2\n.space SIZE\nlater:\n.bits8 -1 'a'\n"), 0644)
+	if err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+
+	var tokens []Token
+	err = Tokenize(file, &tokens)
+	if err != nil {
+		t.Fatalf("error tokenizing: %v", err)
+	}
+
+	resolved, _, err := ResolveTokens(tokens, func(i *Instruction) error {
+		i.Size = 4
+		return nil
+	}, func(arg string) (uint64, error) {
+		return strconv.ParseUint(arg, 0, 64)
+	})
+	if err != nil {
+		t.Fatalf("error resolving tokens: %v", err)
+	}
+
+	var data []byte
+	for _, token := range resolved {
+		data = append(data, token.Value...)
+	}
+	if !slices.Equal(data, []byte{12, 0, 0, 0, 8, 0, 0, 0, 0, 0, 0, 0, 0xff, 'a'}) {
+		t.Fatalf("wrong data: %v", data)
+	}
+
+	for _, directive := range []string{".bits8 256", ".bits16 -0x8001", ".bits32 t0", ".space 0x100000000"} {
+		err := os.WriteFile(file, []byte(directive+"\n"), 0644)
+		if err != nil {
+			t.Fatalf("error writing file: %v", err)
+		}
+
+		tokens = nil
+		err = Tokenize(file, &tokens)
+		if err != nil {
+			t.Fatalf("error tokenizing: %v", err)
+		}
+
+		_, _, err = ResolveTokens(tokens, func(i *Instruction) error {
+			i.Size = 4
+			return nil
+		}, func(arg string) (uint64, error) {
+			return 5, nil
+		})
+		if err == nil {
+			t.Errorf("%v accepted", directive)
+		}
+	}
+
+	// .bss may only reserve zeros.
+	for _, bss := range []struct {
+		directive string
+		end       uint64
+	}{
+		{".bits64 0 SIZE-4", 0x10},
+		{".space 3", 0x3},
+		{".bits8 1", 0},
+	} {
+		err := os.WriteFile(file, []byte(".equ SIZE, 4\n.bss\n"+bss.directive+"\n"), 0644)
+		if err != nil {
+			t.Fatalf("error writing file: %v", err)
+		}
+
+		tokens = nil
+		err = Tokenize(file, &tokens)
+		if err != nil {
+			t.Fatalf("error tokenizing: %v", err)
+		}
+
+		program, err := ResolveProgram(tokens, ResolveOptions{Sections: true}, func(i *Instruction) error {
+			i.Size = 4
+			return nil
+		}, func(arg string) (uint64, error) {
+			return strconv.ParseUint(arg, 0, 64)
+		})
+		if bss.end == 0 && err == nil {
+			t.Errorf("%v accepted in .bss", bss.directive)
+		} else if bss.end != 0 && (err != nil || program.End != bss.end) {
+			t.Errorf("%v in .bss ends at 0x%x (%v), expected 0x%x", bss.directive, program.End, err, bss.end)
+		}
+	}
+}
+
+func TestLocationsOutside(t *testing.T) {
+	InterCtx = &intergo.InterContext{}
+	InterCtx.Init()
+
+	for _, directive := range []string{".org -1", ".org 0x100000000", ".balign 0x100000000", ".align 40", ".org 0x100"} {
+		file := filepath.Join(t.TempDir(), "outside.asm")
+		err := os.WriteFile(file, []byte(directive+"\n"), 0644)
+		if err != nil {
+			t.Fatalf("error writing file: %v", err)
+		}
+
+		var tokens []Token
+		err = Tokenize(file, &tokens)
+		if err != nil {
+			t.Fatalf("error tokenizing: %v", err)
+		}
+
+		_, err = ResolveProgram(tokens, ResolveOptions{MaxAddress: 0xff}, func(i *Instruction) error {
+			i.Size = 1
+			return nil
+		}, func(arg string) (uint64, error) {
+			return strconv.ParseUint(arg, 0, 64)
+		})
+		if err == nil {
+			t.Errorf("%v accepted outside of the address space", directive)
+		}
+	}
+}
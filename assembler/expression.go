@@ -0,0 +1,287 @@
+package assembler
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Characters that make an argument an expression instead of a single name or
+// number.
+const EXPRESSION_CHARS = "+-*/<>&|~()'%"
+
+// Names an argument may use.
+type symbolTable struct {
+	constants map[string]uint64
+	labels    map[string]uint64
+	// Functions called as %name(expression).
+	functions    map[string]func(uint64) uint64
+	translateArg func(string) (uint64, error)
+}
+
+// Translates an argument: a constant, a label, an expression or anything else
+// understood by translateArg (e.g., a register or a number). Expressions may
+// only use numbers, characters, constants and labels.
+func (s *symbolTable) translate(arg string) (uint64, error) {
+	if value, ok := s.constants[arg]; ok {
+		return value, nil
+	}
+	if value, ok := s.labels[arg]; ok {
+		return value, nil
+	}
+	if !strings.ContainsAny(arg, EXPRESSION_CHARS) {
+		return s.translateArg(arg)
+	}
+
+	p := expressionParser{expr: arg, symbols: s}
+	value, err := p.or()
+	if err != nil {
+		return 0, err
+	}
+	p.skipSpaces()
+	if p.pos < len(p.expr) {
+		return 0, fmt.Errorf(InterCtx.Get("unexpected %q in expression %v"), p.expr[p.pos:], p.expr)
+	}
+	return value, nil
+}
+
+// Translates arguments that can only be numbers, such as the values of data
+// directives.
+func translateNumber(arg string) (uint64, error) {
+	value, err := strconv.ParseUint(arg, 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf(InterCtx.Get("cannot parse %v as a number"), arg)
+	}
+	return value, nil
+}
+
+// Whether value fits in a number with the given bits, either as an unsigned
+// number or as a negative one (e.g., -1).
+func fits(value uint64, bits int) bool {
+	if bits >= 64 {
+		return true
+	}
+	return value>>bits == 0 || int64(value)>>(bits-1) == -1
+}
+
+// Returns %hi and %lo functions, which split a 32 bits address in an upper part
+// and a lower part with lowBits bits, for loading it with two instructions
+// (e.g., lui and addi). As the lower part is sign extended by these
+// instructions, the upper part is rounded so both add up to the address.
+func HiLo(lowBits uint) map[string]func(uint64) uint64 {
+	return map[string]func(uint64) uint64{
+		"hi": func(v uint64) uint64 {
+			return uint64(uint32(v)+(1<<(lowBits-1))) >> lowBits
+		},
+		"lo": func(v uint64) uint64 {
+			return v & (1<<lowBits - 1)
+		},
+	}
+}
+
+// Recursive descent parser of expressions, with the precedence of C: unary
+// operators, then * and /, + and -, << and >>, & and finally |. Arithmetic is
+// done with 64 bits words, and / and >> are signed.
+type expressionParser struct {
+	expr    string
+	pos     int
+	symbols *symbolTable
+}
+
+func (p *expressionParser) skipSpaces() {
+	for p.pos < len(p.expr) && (p.expr[p.pos] == ' ' || p.expr[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// Consumes op if it's next in the expression.
+func (p *expressionParser) accept(op string) bool {
+	p.skipSpaces()
+	if strings.HasPrefix(p.expr[p.pos:], op) {
+		p.pos += len(op)
+		return true
+	}
+	return false
+}
+
+func (p *expressionParser) or() (uint64, error) {
+	value, err := p.and()
+	for err == nil && p.accept("|") {
+		var right uint64
+		right, err = p.and()
+		value |= right
+	}
+	return value, err
+}
+
+func (p *expressionParser) and() (uint64, error) {
+	value, err := p.shift()
+	for err == nil && p.accept("&") {
+		var right uint64
+		right, err = p.shift()
+		value &= right
+	}
+	return value, err
+}
+
+func (p *expressionParser) shift() (uint64, error) {
+	value, err := p.sum()
+	for err == nil {
+		var right uint64
+		if p.accept("<<") {
+			right, err = p.sum()
+			value <<= right
+		} else if p.accept(">>") {
+			right, err = p.sum()
+			value = uint64(int64(value) >> right)
+		} else {
+			break
+		}
+	}
+	return value, err
+}
+
+func (p *expressionParser) sum() (uint64, error) {
+	value, err := p.product()
+	for err == nil {
+		var right uint64
+		if p.accept("+") {
+			right, err = p.product()
+			value += right
+		} else if p.accept("-") {
+			right, err = p.product()
+			value -= right
+		} else {
+			break
+		}
+	}
+	return value, err
+}
+
+func (p *expressionParser) product() (uint64, error) {
+	value, err := p.unary()
+	for err == nil {
+		var right uint64
+		if p.accept("*") {
+			right, err = p.unary()
+			value *= right
+		} else if p.accept("/") {
+			right, err = p.unary()
+			if err == nil && right == 0 {
+				return 0, fmt.Errorf(InterCtx.Get("division by zero in expression %v"), p.expr)
+			}
+			// Avoid the overflow of the most negative number divided by -1.
+			if int64(value) == math.MinInt64 && int64(right) == -1 {
+				continue
+			}
+			value = uint64(int64(value) / int64(right))
+		} else {
+			break
+		}
+	}
+	return value, err
+}
+
+func (p *expressionParser) unary() (uint64, error) {
+	if p.accept("-") {
+		value, err := p.unary()
+		return -value, err
+	}
+	if p.accept("~") {
+		value, err := p.unary()
+		return ^value, err
+	}
+	if p.accept("+") {
+		return p.unary()
+	}
+	return p.primary()
+}
+
+func (p *expressionParser) primary() (uint64, error) {
+	p.skipSpaces()
+	if p.pos >= len(p.expr) {
+		return 0, fmt.Errorf(InterCtx.Get("unexpected end of expression %v"), p.expr)
+	}
+
+	switch c := p.expr[p.pos]; {
+	case c == '(':
+		p.pos++
+		value, err := p.or()
+		if err != nil {
+			return 0, err
+		}
+		if !p.accept(")") {
+			return 0, fmt.Errorf(InterCtx.Get("missing ) in expression %v"), p.expr)
+		}
+		return value, nil
+	case c == '\'':
+		return p.character()
+	case c == '%':
+		p.pos++
+		name := p.name()
+		function, ok := p.symbols.functions[name]
+		if !ok {
+			return 0, fmt.Errorf(InterCtx.Get("unknown function %%%v in expression %v"), name, p.expr)
+		}
+		if !p.accept("(") {
+			return 0, fmt.Errorf(InterCtx.Get("missing ( in expression %v"), p.expr)
+		}
+		value, err := p.or()
+		if err != nil {
+			return 0, err
+		}
+		if !p.accept(")") {
+			return 0, fmt.Errorf(InterCtx.Get("missing ) in expression %v"), p.expr)
+		}
+		return function(value), nil
+	case '0' <= c && c <= '9':
+		number := p.name()
+		value, err := strconv.ParseUint(number, 0, 64)
+		if err != nil {
+			return 0, fmt.Errorf(InterCtx.Get("cannot parse %v as a number in expression %v"), number, p.expr)
+		}
+		return value, nil
+	}
+
+	name := p.name()
+	if name == "" {
+		return 0, fmt.Errorf(InterCtx.Get("unexpected %q in expression %v"), p.expr[p.pos:], p.expr)
+	}
+	if value, ok := p.symbols.constants[name]; ok {
+		return value, nil
+	}
+	if value, ok := p.symbols.labels[name]; ok {
+		return value, nil
+	}
+	// Registers and other names of the backend are not values.
+	return 0, fmt.Errorf(InterCtx.Get("unknown name %v in expression %v"), name, p.expr)
+}
+
+// Consumes a name or number: everything until an operator or space.
+func (p *expressionParser) name() string {
+	start := p.pos
+	for p.pos < len(p.expr) && !strings.ContainsRune(EXPRESSION_CHARS+" \t", rune(p.expr[p.pos])) {
+		p.pos++
+	}
+	return p.expr[start:p.pos]
+}
+
+// Consumes a character literal, such as 'a' or '\n'.
+func (p *expressionParser) character() (uint64, error) {
+	rest := p.expr[p.pos:]
+	if len(rest) >= 3 && rest[1] != '\\' && rest[2] == '\'' {
+		p.pos += 3
+		return uint64(rest[1]), nil
+	}
+
+	escapes := map[byte]uint64{'n': '\n', 't': '\t', 'r': '\r', '0': 0, '\\': '\\', '\'': '\''}
+	if len(rest) >= 4 && rest[1] == '\\' && rest[3] == '\'' {
+		if value, ok := escapes[rest[2]]; ok {
+			p.pos += 4
+			return value, nil
+		}
+	}
+
+	return 0, fmt.Errorf(InterCtx.Get("malformed character literal in expression %v"), p.expr)
+}
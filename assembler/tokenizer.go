@@ -1,60 +1,84 @@
 package assembler
 
 import (
+	"cmp"
 	"errors"
 	"fmt"
+	"slices"
 	"strconv"
 	"strings"
 )
 
-// Adds some specific bytes. Like a literal but easier with numbers. The size
-// argument shall be 1, 2, 4 or 8.
-func bitsDirective(fileName *string, lineNum int, args *string, size int, tokens *[]Token) error {
-	*args = strings.TrimSpace(*args)
-	if len(*args) == 0 {
-		return fmt.Errorf(InterCtx.Get("%v:%v: Expected literal bytes after bits directive"), *fileName, lineNum)
-	}
+// Maximum depth of macros expanded inside other macros, so recursive macros
+// fail instead of expanding forever.
+const MAX_MACRO_DEPTH = 64
 
-	bitsize := size * 8
+// A macro defined with .macro and .endm.
+type macro struct {
+	name   string
+	params []string
+	// Lines, already uncommented and trimmed.
+	body []string
+	line int
+}
 
-	argsSlice := strings.Split(*args, " ")
-	literal := make([]byte, size*len(argsSlice))
-	for i, arg := range argsSlice {
-		n, err := strconv.ParseUint(arg, 0, bitsize)
-		if err != nil {
-			return fmt.Errorf(InterCtx.Get("%v:%v: Cannot convert %v to a %v bits number"), *fileName, lineNum, arg, bitsize)
-		}
+// State shared by every file tokenized by a call to Tokenize.
+type tokenizerState struct {
+	macros map[string]*macro
+	// Macro being defined, or nil.
+	defining *macro
+	// Number of macros expanded so far, used to create unique labels with
+	// \@.
+	expansions int
+	// Depth of the current macro expansion.
+	depth int
+}
 
-		for j := 0; j < size; j++ {
-			literal[i*size+j] = byte(n)
-			n = n >> 8
-		}
+// Adds some specific bytes. Like a literal but easier with numbers. The values
+// are separated by spaces and only translated when resolving the tokens, so
+// they may be constants, labels or expressions without spaces.
+func bitsDirective(fileName *string, lineNum int, directive string, args *string, tokens *[]Token) error {
+	values := strings.Fields(*args)
+	if len(values) == 0 {
+		return fmt.Errorf(InterCtx.Get("%v:%v: Expected literal bytes after bits directive"), *fileName, lineNum)
 	}
 
 	*tokens = append(*tokens, Token{
 		Line:  lineNum,
 		File:  fileName,
-		Type:  TOKEN_LITERAL,
-		Value: literal,
+		Type:  TOKEN_DATA,
+		Value: []byte(directive),
 	})
+	for _, value := range values {
+		*tokens = append(*tokens, Token{
+			Line:  lineNum,
+			File:  fileName,
+			Type:  TOKEN_ARG,
+			Value: []byte(value),
+		})
+	}
 
 	return nil
 }
 
-// Creates an empty literal with the number in *args as the size (in bytes).
+// Creates an empty literal with the value in *args as the size (in bytes). As
+// with bits directives, the value is only translated when resolving the tokens.
 func spaceDirective(fileName *string, lineNum int, args *string, tokens *[]Token) error {
-	if len(*args) == 0 {
+	value := strings.TrimSpace(*args)
+	if len(value) == 0 {
 		return fmt.Errorf(InterCtx.Get("%v:%v: Expected a number of bytes after space directive"), *fileName, lineNum)
 	}
-	n, err := strconv.ParseUint(*args, 0, 64)
-	if err != nil {
-		return fmt.Errorf(InterCtx.Get("%v:%v: Cannot create space: Cannot parse %v to number: %v"), *fileName, lineNum, *args, err)
-	}
+
 	*tokens = append(*tokens, Token{
 		Line:  lineNum,
 		File:  fileName,
-		Type:  TOKEN_LITERAL,
-		Value: make([]byte, n),
+		Type:  TOKEN_DATA,
+		Value: []byte("space"),
+	}, Token{
+		Line:  lineNum,
+		File:  fileName,
+		Type:  TOKEN_ARG,
+		Value: []byte(value),
 	})
 
 	return nil
@@ -118,8 +142,149 @@ func parseLiteral(fileName *string, line *string, lineNum int, tokens *[]Token)
 	})
 }
 
+// Defines a constant from "NAME, value". The value is only translated when
+// resolving the tokens.
+func constantDirective(fileName *string, lineNum int, directive string, args *string, tokens *[]Token) error {
+	name, value, _ := strings.Cut(*args, ",")
+	name = strings.TrimSpace(name)
+	value = strings.TrimSpace(value)
+	if len(name) == 0 || len(value) == 0 || strings.ContainsAny(name, " \t") {
+		return fmt.Errorf(InterCtx.Get("%v:%v: Expected a name and a value after %v directive"), *fileName, lineNum, directive)
+	}
+
+	*tokens = append(*tokens, Token{
+		Line:  lineNum,
+		File:  fileName,
+		Type:  TOKEN_CONSTANT,
+		Value: []byte(name),
+	}, Token{
+		Line:  lineNum,
+		File:  fileName,
+		Type:  TOKEN_ARG,
+		Value: []byte(value),
+	})
+
+	return nil
+}
+
+// Creates a location directive (.align, .balign or .org) with it's value, which
+// is only translated when resolving the tokens.
+func locationDirective(fileName *string, lineNum int, directive string, args *string, tokens *[]Token) error {
+	value := strings.TrimSpace(*args)
+	if len(value) == 0 {
+		return fmt.Errorf(InterCtx.Get("%v:%v: Expected a value after %v directive"), *fileName, lineNum, directive)
+	}
+
+	*tokens = append(*tokens, Token{
+		Line:  lineNum,
+		File:  fileName,
+		Type:  TOKEN_LOCATION,
+		Value: []byte(directive),
+	}, Token{
+		Line:  lineNum,
+		File:  fileName,
+		Type:  TOKEN_ARG,
+		Value: []byte(value),
+	})
+
+	return nil
+}
+
+// Starts defining a macro from "NAME param1, param2, ...". The next lines are
+// its body, until a .endm.
+func macroDirective(fileName *string, lineNum int, args *string, state *tokenizerState) error {
+	name, params, _ := strings.Cut(strings.TrimSpace(*args), " ")
+	if len(name) == 0 {
+		return fmt.Errorf(InterCtx.Get("%v:%v: Expected a macro name"), *fileName, lineNum)
+	}
+
+	m := &macro{name: name, line: lineNum}
+	if strings.TrimSpace(params) != "" {
+		for _, param := range strings.Split(params, ",") {
+			param = strings.TrimSpace(param)
+			if len(param) == 0 {
+				return fmt.Errorf(InterCtx.Get("%v:%v: Expected a parameter name"), *fileName, lineNum)
+			}
+			m.params = append(m.params, param)
+		}
+	}
+
+	state.defining = m
+	return nil
+}
+
+// Adds a line to the body of the macro being defined, or finishes it in case
+// of a .endm. Line shall already be trimmed.
+func collectMacroLine(fileName *string, line string, lineNum int, state *tokenizerState) error {
+	if line[0] == '.' {
+		directive, _, _ := strings.Cut(strings.TrimSpace(line[1:]), " ")
+		switch directive {
+		case "endm":
+			state.macros[state.defining.name] = state.defining
+			state.defining = nil
+			return nil
+		case "macro":
+			return fmt.Errorf(InterCtx.Get("%v:%v: Macros cannot be defined inside other macros"), *fileName, lineNum)
+		}
+	}
+
+	state.defining.body = append(state.defining.body, line)
+	return nil
+}
+
+// Expands a macro, tokenizing its body with the parameters substituted by the
+// arguments. Every token has the line of the macro invocation, so the debugger
+// shows the invocation instead of the body.
+func expandMacro(fileName *string, lineNum int, m *macro, args string, tokens *[]Token, state *tokenizerState) error {
+	values := []string{}
+	if strings.TrimSpace(args) != "" {
+		for _, arg := range strings.Split(args, ",") {
+			values = append(values, strings.TrimSpace(arg))
+		}
+	}
+	if len(values) != len(m.params) {
+		return fmt.Errorf(InterCtx.Get("%v:%v: Macro %v expects %v arguments, got %v"), *fileName, lineNum, m.name, len(m.params), len(values))
+	}
+	if state.depth >= MAX_MACRO_DEPTH {
+		return fmt.Errorf(InterCtx.Get("%v:%v: Macro %v expanded too deeply (is it recursive?)"), *fileName, lineNum, m.name)
+	}
+
+	// \@ is substituted by the number of the expansion, so macros can
+	// create unique labels.
+	state.expansions++
+	pairs := []string{"\\@", strconv.Itoa(state.expansions)}
+
+	// Longer names first, so a parameter is not substituted inside another
+	// one that starts with it.
+	params := make([]int, len(m.params))
+	for i := range params {
+		params[i] = i
+	}
+	slices.SortFunc(params, func(a, b int) int {
+		return cmp.Compare(len(m.params[b]), len(m.params[a]))
+	})
+	for _, i := range params {
+		pairs = append(pairs, "\\"+m.params[i], values[i])
+	}
+	replacer := strings.NewReplacer(pairs...)
+
+	state.depth++
+	defer func() {
+		state.depth--
+	}()
+	for _, line := range m.body {
+		line = replacer.Replace(line)
+		err := parseLine(fileName, &line, lineNum, tokens, state)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // Line shall already be trimmed.
-func parseDirective(fileName *string, line *string, lineNum int, tokens *[]Token) error {
+func parseDirective(fileName *string, line *string, lineNum int, tokens *[]Token, state *tokenizerState) error {
 	if len(*line) == 0 {
 		return fmt.Errorf(InterCtx.Get("%v:%v: Expected a directive name"), *fileName, lineNum)
 	}
@@ -131,7 +296,7 @@ func parseDirective(fileName *string, line *string, lineNum int, tokens *[]Token
 		if len(file) == 0 {
 			return fmt.Errorf(InterCtx.Get("%v:%v: Expected file name to include"), *fileName, lineNum)
 		}
-		return Tokenize(file, tokens)
+		return tokenizeFile(file, tokens, state)
 	case "literal":
 		lit := strings.TrimSpace(arg)
 		if len(lit) == 0 {
@@ -139,16 +304,29 @@ func parseDirective(fileName *string, line *string, lineNum int, tokens *[]Token
 		}
 		parseLiteral(fileName, &lit, lineNum, tokens)
 		return nil
-	case "bits8":
-		return bitsDirective(fileName, lineNum, &arg, 1, tokens)
-	case "bits16":
-		return bitsDirective(fileName, lineNum, &arg, 2, tokens)
-	case "bits32":
-		return bitsDirective(fileName, lineNum, &arg, 4, tokens)
-	case "bits64":
-		return bitsDirective(fileName, lineNum, &arg, 8, tokens)
+	case "bits8", "bits16", "bits32", "bits64":
+		return bitsDirective(fileName, lineNum, name, &arg, tokens)
 	case "space":
 		return spaceDirective(fileName, lineNum, &arg, tokens)
+	case "equ", "set":
+		return constantDirective(fileName, lineNum, name, &arg, tokens)
+	case "align", "balign", "org":
+		return locationDirective(fileName, lineNum, name, &arg, tokens)
+	case "text", "data", "bss":
+		if strings.TrimSpace(arg) != "" {
+			return fmt.Errorf(InterCtx.Get("%v:%v: Unexpected argument after %v directive"), *fileName, lineNum, name)
+		}
+		*tokens = append(*tokens, Token{
+			Line:  lineNum,
+			File:  fileName,
+			Type:  TOKEN_SECTION,
+			Value: []byte(name),
+		})
+		return nil
+	case "macro":
+		return macroDirective(fileName, lineNum, &arg, state)
+	case "endm":
+		return fmt.Errorf(InterCtx.Get("%v:%v: .endm without .macro"), *fileName, lineNum)
 	}
 
 	return fmt.Errorf(InterCtx.Get("%v:%v: Unknown directive %v"), *fileName, lineNum, name)
@@ -177,7 +355,7 @@ func parseInstruction(fileName *string, line *string, lineNum int, tokens *[]Tok
 	}
 }
 
-func parseLine(fileName *string, line *string, lineNum int, tokens *[]Token) error {
+func parseLine(fileName *string, line *string, lineNum int, tokens *[]Token, state *tokenizerState) error {
 	// This uncomments and trims the line.
 	*line, _, _ = strings.Cut(*line, ";")
 	*line = strings.TrimSpace(*line)
@@ -185,6 +363,10 @@ func parseLine(fileName *string, line *string, lineNum int, tokens *[]Token) err
 		return nil
 	}
 
+	if state.defining != nil {
+		return collectMacroLine(fileName, *line, lineNum, state)
+	}
+
 	// If a literal.
 	if (*line)[0] == '#' {
 		if len(*line) <= 1 {
@@ -198,7 +380,7 @@ func parseLine(fileName *string, line *string, lineNum int, tokens *[]Token) err
 	if (*line)[0] == '.' {
 		*line = (*line)[1:]
 		*line = strings.TrimSpace(*line)
-		return parseDirective(fileName, line, lineNum, tokens)
+		return parseDirective(fileName, line, lineNum, tokens, state)
 	}
 
 	// Now we check if there's a label declared there.
@@ -214,6 +396,11 @@ func parseLine(fileName *string, line *string, lineNum int, tokens *[]Token) err
 
 	beg = strings.TrimSpace(beg)
 	if len(beg) != 0 {
+		mnemonic, args, _ := strings.Cut(beg, " ")
+		if m, isMacro := state.macros[mnemonic]; isMacro {
+			return expandMacro(fileName, lineNum, m, args, tokens, state)
+		}
+
 		// Finally we put an instruction there.
 		parseInstruction(fileName, &beg, lineNum, tokens)
 	}
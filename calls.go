@@ -0,0 +1,695 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gboncoffee/egg/machine"
+)
+
+// Handles the standard calls performed by a machine, except BREAK and EXIT,
+// which stop the execution and thus are handled by whoever is running it.
+type callHandler struct {
+	m      machine.Machine
+	in     *bufio.Reader
+	out    io.Writer
+	errOut io.Writer
+	// The console, used for input and output unless they're redirected to
+	// files.
+	consoleIn  *bufio.Reader
+	consoleOut io.Writer
+	// Files input and output are redirected to, or nil.
+	inFile  *os.File
+	outFile *os.File
+	// In interactive mode (i.e., the debugger), READ calls read at most a
+	// single line instead of filling the buffer.
+	interactive bool
+	// Context of the current run, which cancels waiting for input when
+	// it's done. Nil never cancels.
+	ctx context.Context
+	// Current end of the heap.
+	heap uint64
+	// Word width of the machine.
+	width uint64
+	// Directory file calls are restricted to. Empty disables them.
+	fsRoot string
+	// Open files by descriptor.
+	files map[uint64]*os.File
+	// Input, time and random numbers got by the program, replayed in the
+	// next runs.
+	inputs inputLog
+	// Command line arguments and environment (as KEY=VALUE) of the program.
+	args []string
+	env  []string
+}
+
+// Largest buffer allocated at once by reads, so programs cannot make the
+// emulator allocate as much memory as they ask for.
+const MAX_READ_BUFFER = 64 * 1024
+
+// File descriptors of the standard input, output and error.
+const (
+	STDIN_FD  = 0
+	STDOUT_FD = 1
+	STDERR_FD = 2
+)
+
+// Reader whose reads return early when the context of a call handler is done,
+// e.g., when the program is stopped while waiting for input. Each read is done
+// by another goroutine, which keeps waiting after a cancelled read, so it's
+// data is returned by the next one.
+type cancelableReader struct {
+	r io.Reader
+	h *callHandler
+	// Result of the read in progress, or nil if there's none.
+	pending chan readResult
+	// Data read but not returned yet.
+	rest []byte
+}
+
+type readResult struct {
+	data []byte
+	err  error
+}
+
+func (c *cancelableReader) Read(p []byte) (int, error) {
+	if len(c.rest) > 0 {
+		n := copy(p, c.rest)
+		c.rest = c.rest[n:]
+		return n, nil
+	}
+
+	if c.pending == nil {
+		c.pending = make(chan readResult, 1)
+		buf := make([]byte, len(p))
+		go func(pending chan<- readResult) {
+			n, err := c.r.Read(buf)
+			pending <- readResult{buf[:n], err}
+		}(c.pending)
+	}
+
+	var done <-chan struct{}
+	if c.h.ctx != nil {
+		done = c.h.ctx.Done()
+	}
+	select {
+	case result := <-c.pending:
+		c.pending = nil
+		n := copy(p, result.data)
+		c.rest = result.data[n:]
+		return n, result.err
+	case <-done:
+		return 0, c.h.ctx.Err()
+	}
+}
+
+// Creates a call handler for m, running a program with programSize bytes.
+func newCallHandler(m machine.Machine, in io.Reader, out io.Writer, programSize uint64) *callHandler {
+	h := &callHandler{
+		m:          m,
+		out:        out,
+		errOut:     os.Stderr,
+		consoleOut: out,
+		width:      uint64(m.ArchitectureInfo().WordWidth),
+		files:      make(map[uint64]*os.File),
+	}
+	h.consoleIn = bufio.NewReader(&cancelableReader{r: in, h: h})
+	h.in = h.consoleIn
+	h.reset(programSize)
+	return h
+}
+
+// Reads the program input from a file (or device, e.g., another terminal).
+// An empty name reads from the console again.
+func (h *callHandler) setInput(name string) error {
+	if name == "" {
+		h.closeInput()
+		h.in = h.consoleIn
+		h.inputs.clear()
+		return nil
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	h.closeInput()
+	h.inFile = f
+	h.in = bufio.NewReader(&cancelableReader{r: f, h: h})
+	h.inputs.clear()
+	return nil
+}
+
+func (h *callHandler) closeInput() {
+	if h.inFile != nil {
+		_ = h.inFile.Close()
+		h.inFile = nil
+	}
+}
+
+// Writes the program output to a file (or device), truncating it. An empty
+// name writes to the console again.
+func (h *callHandler) setOutput(name string) error {
+	if name == "" {
+		h.closeOutput()
+		h.out = h.consoleOut
+		return nil
+	}
+
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	h.closeOutput()
+	h.outFile = f
+	h.out = f
+	return nil
+}
+
+func (h *callHandler) closeOutput() {
+	if h.outFile != nil {
+		_ = h.outFile.Close()
+		h.outFile = nil
+	}
+}
+
+// Reads the program input from and writes the program output to the same
+// device, e.g., another terminal. As the device is opened twice, regular files
+// are rejected, so they're never truncated. An empty name uses the console
+// again.
+func (h *callHandler) setTTY(name string) error {
+	if name != "" {
+		info, err := os.Stat(name)
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeDevice == 0 {
+			return fmt.Errorf(machine.InterCtx.Get("%v is not a device"), name)
+		}
+	}
+
+	err := h.setInput(name)
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		return h.setOutput("")
+	}
+
+	f, err := os.OpenFile(name, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	h.closeOutput()
+	h.outFile = f
+	h.out = f
+	return nil
+}
+
+// Sets the command line arguments and environment of the program. They're
+// written to memory again every time the handler is reset, as the memory is
+// cleared when the program is loaded.
+func (h *callHandler) setArguments(args []string, env []string) error {
+	if len(args) == 0 && len(env) == 0 {
+		h.args, h.env = nil, nil
+		return nil
+	}
+
+	setter, ok := h.m.(machine.ArgumentsSetter)
+	if !ok {
+		return errors.New(machine.InterCtx.Get("Program arguments are not supported for the selected backend."))
+	}
+	err := setter.SetArguments(args, env)
+	if err != nil {
+		return err
+	}
+
+	h.args, h.env = args, env
+	return nil
+}
+
+// Returns the heap to it's original state, just after the program (aligned to
+// the word size), and closes every file. The input kept from the previous runs
+// is replayed, so they're reproducible. Must be called after loading the
+// program, as it also writes the program arguments.
+func (h *callHandler) reset(programSize uint64) {
+	end := programSize
+	if layout, ok := h.m.(machine.LayoutSetter); ok {
+		end = layout.ProgramEnd()
+	}
+	align := max(h.width/8, 1)
+	h.heap = (end + align - 1) / align * align
+
+	for fd, file := range h.files {
+		_ = file.Close()
+		delete(h.files, fd)
+	}
+
+	h.inputs.rewind()
+
+	if len(h.args) > 0 || len(h.env) > 0 {
+		err := h.m.(machine.ArgumentsSetter).SetArguments(h.args, h.env)
+		if err != nil {
+			fmt.Fprintln(h.errOut, err)
+		}
+	}
+}
+
+// State of the handler changed by calls, which the debugger restores when
+// undoing them. Files are not part of it, so calls on them cannot be undone.
+type callState struct {
+	heap uint64
+	// Position of the next input event. Undone calls get the same event
+	// again when executed again.
+	nextInput int
+}
+
+func (h *callHandler) state() callState {
+	return callState{heap: h.heap, nextInput: h.inputs.next}
+}
+
+func (h *callHandler) restore(s callState) {
+	h.heap = s.heap
+	h.inputs.next = min(s.nextInput, len(h.inputs.events))
+}
+
+// Returns the next recorded event for the call, or gets a new one with live
+// and records it.
+func (h *callHandler) input(call uint64, live func() (inputEvent, error)) (inputEvent, error) {
+	e, ok, err := h.inputs.replay(call)
+	if err != nil {
+		fmt.Fprintln(h.errOut, err)
+	}
+	if ok {
+		return e, nil
+	}
+
+	e, err = live()
+	if err != nil {
+		return e, err
+	}
+	e.Call = call
+	return e, h.inputs.add(e)
+}
+
+// Same as input, but for calls that only get a value.
+func (h *callHandler) inputValue(call uint64, live func() uint64) (uint64, error) {
+	e, err := h.input(call, func() (inputEvent, error) {
+		return inputEvent{Value: live()}, nil
+	})
+	return e.Value, err
+}
+
+// Truncates a value to the word width.
+func (h *callHandler) truncate(value uint64) uint64 {
+	if h.width >= 64 {
+		return value
+	}
+	return value & (1<<h.width - 1)
+}
+
+// Sign extends a value from the word width.
+func (h *callHandler) signed(value uint64) int64 {
+	if h.width >= 64 {
+		return int64(value)
+	}
+	shift := 64 - h.width
+	return int64(value<<shift) >> shift
+}
+
+// Limits a size asked by the program to the bytes left in the address space
+// after addr. Size must not be 0.
+func (h *callHandler) clamp(addr uint64, size uint64) uint64 {
+	last := h.truncate(math.MaxUint64)
+	if addr <= last && size-1 > last-addr {
+		return last - addr + 1
+	}
+	return size
+}
+
+// Reads of 0 bytes do nothing, as the memory range would be empty.
+func (h *callHandler) read(addr uint64, size uint64) error {
+	if size == 0 {
+		return nil
+	}
+	size = h.clamp(addr, size)
+	e, err := h.input(machine.SYS_READ, func() (inputEvent, error) {
+		var data []uint8
+		var err error
+		if h.interactive {
+			buf := make([]uint8, min(size, MAX_READ_BUFFER))
+			var n int
+			n, err = h.in.Read(buf)
+			data = buf[:n]
+		} else {
+			// Whatever was read before the end of the input is kept.
+			data, err = io.ReadAll(io.LimitReader(h.in, int64(min(size, math.MaxInt64))))
+		}
+		if err != nil {
+			return inputEvent{}, fmt.Errorf(machine.InterCtx.Get("error reading input: %v"), err)
+		}
+		return inputEvent{Data: data}, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// The rest of the buffer is zeroed.
+	data := e.Data
+	for size > 0 {
+		chunk := make([]uint8, min(size, MAX_READ_BUFFER))
+		n := copy(chunk, data)
+		data = data[n:]
+		err := h.m.SetMemoryChunk(addr, chunk)
+		if err != nil {
+			return err
+		}
+		addr += uint64(len(chunk))
+		size -= uint64(len(chunk))
+	}
+	return nil
+}
+
+// Reads a line of input for the call.
+func (h *callHandler) readLine(call uint64) (string, error) {
+	e, err := h.input(call, func() (inputEvent, error) {
+		line, err := h.in.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return inputEvent{}, fmt.Errorf(machine.InterCtx.Get("error reading input: %v"), err)
+		}
+		return inputEvent{Data: []uint8(line)}, nil
+	})
+	return string(e.Data), err
+}
+
+func (h *callHandler) readInt() (uint64, error) {
+	line, err := h.readLine(machine.SYS_READ_INT)
+	if err != nil {
+		return 0, err
+	}
+
+	line = strings.TrimSpace(line)
+	value, err := strconv.ParseInt(line, 0, 64)
+	if err != nil {
+		// Allow unsigned values that do not fit in a int64.
+		unsigned, uerr := strconv.ParseUint(line, 0, 64)
+		if uerr != nil {
+			return 0, fmt.Errorf(machine.InterCtx.Get("cannot parse %v as integer"), line)
+		}
+		return unsigned, nil
+	}
+
+	return uint64(value), nil
+}
+
+func (h *callHandler) readString(addr uint64, size uint64) error {
+	if size == 0 {
+		return nil
+	}
+
+	line, err := h.readLine(machine.SYS_READ_STRING)
+	if err != nil {
+		return err
+	}
+
+	buf := []uint8(line)
+	if uint64(len(buf)) > size-1 {
+		buf = buf[:size-1]
+	}
+	return h.m.SetMemoryChunk(addr, append(buf, 0))
+}
+
+// Returns the NUL-terminated string at addr.
+func (h *callHandler) getString(addr uint64) ([]uint8, error) {
+	var s []uint8
+	for {
+		b, err := h.m.GetMemory(addr)
+		if err != nil {
+			return nil, err
+		}
+		if b == 0 {
+			return s, nil
+		}
+		s = append(s, b)
+		addr++
+	}
+}
+
+func (h *callHandler) printString(addr uint64) error {
+	s, err := h.getString(addr)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.out.Write(s)
+	return err
+}
+
+// Opens a file used by the program inside the file system root. The name is
+// always relative to the root (i.e., "/a" and "a" are the same file) and
+// symbolic links cannot point outside of it, which is enforced by os.Root while
+// opening, so the file cannot be swapped after being checked.
+func (h *callHandler) openSandboxed(name string, mode int) (*os.File, error) {
+	if h.fsRoot == "" {
+		return nil, errors.New(machine.InterCtx.Get("file calls are disabled"))
+	}
+
+	root, err := os.OpenRoot(h.fsRoot)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+
+	file := strings.TrimPrefix(path.Clean("/"+name), "/")
+	if file == "" {
+		file = "."
+	}
+	return root.OpenFile(filepath.FromSlash(file), mode, 0644)
+}
+
+func (h *callHandler) open(addr uint64, flags uint64) (uint64, error) {
+	name, err := h.getString(addr)
+	if err != nil {
+		return 0, err
+	}
+	var mode int
+	switch flags {
+	case machine.OPEN_READ:
+		mode = os.O_RDONLY
+	case machine.OPEN_WRITE:
+		mode = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	case machine.OPEN_APPEND:
+		mode = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	default:
+		return 0, fmt.Errorf(machine.InterCtx.Get("unknown open flags: %v"), flags)
+	}
+
+	f, err := h.openSandboxed(string(name), mode)
+	if err != nil {
+		return 0, err
+	}
+
+	// Use the lowest free descriptor, as POSIX.
+	fd := uint64(STDERR_FD + 1)
+	for h.files[fd] != nil {
+		fd++
+	}
+	h.files[fd] = f
+	return fd, nil
+}
+
+func (h *callHandler) readFd(fd uint64, addr uint64, size uint64) (uint64, error) {
+	if size == 0 {
+		return 0, nil
+	}
+	// Reads may return less than asked, so the buffer is limited.
+	buf := make([]uint8, min(h.clamp(addr, size), MAX_READ_BUFFER))
+	var n int
+	var err error
+	if fd == STDIN_FD {
+		var e inputEvent
+		e, err = h.input(machine.SYS_READ_FD, func() (inputEvent, error) {
+			n, err := h.in.Read(buf)
+			if err != nil && err != io.EOF {
+				return inputEvent{}, err
+			}
+			return inputEvent{Data: buf[:n]}, nil
+		})
+		n = copy(buf, e.Data)
+	} else if file, ok := h.files[fd]; ok {
+		n, err = file.Read(buf)
+	} else {
+		return 0, fmt.Errorf(machine.InterCtx.Get("bad file descriptor: %v"), fd)
+	}
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+
+	return uint64(n), h.m.SetMemoryChunk(addr, buf[:n])
+}
+
+func (h *callHandler) writeFd(fd uint64, addr uint64, size uint64) (uint64, error) {
+	if size == 0 {
+		return 0, nil
+	}
+	buf, err := h.m.GetMemoryChunk(addr, size)
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+	switch fd {
+	case STDOUT_FD:
+		n, err = h.out.Write(buf)
+	case STDERR_FD:
+		n, err = h.errOut.Write(buf)
+	default:
+		file, ok := h.files[fd]
+		if !ok {
+			return 0, fmt.Errorf(machine.InterCtx.Get("bad file descriptor: %v"), fd)
+		}
+		n, err = file.Write(buf)
+	}
+
+	return uint64(n), err
+}
+
+func (h *callHandler) closeFd(fd uint64) (uint64, error) {
+	if fd <= STDERR_FD {
+		return 0, nil
+	}
+	file, ok := h.files[fd]
+	if !ok {
+		return 0, fmt.Errorf(machine.InterCtx.Get("bad file descriptor: %v"), fd)
+	}
+
+	delete(h.files, fd)
+	return 0, file.Close()
+}
+
+func (h *callHandler) lseek(fd uint64, offset int64, whence uint64) (uint64, error) {
+	file, ok := h.files[fd]
+	if !ok {
+		return 0, fmt.Errorf(machine.InterCtx.Get("bad file descriptor: %v"), fd)
+	}
+	if whence > machine.SEEK_END {
+		return 0, fmt.Errorf(machine.InterCtx.Get("unknown whence: %v"), whence)
+	}
+
+	// Our whences are the same as the io ones.
+	pos, err := file.Seek(offset, int(whence))
+	return uint64(pos), err
+}
+
+// Writes the result of a file call. Errors are returned to the program as -1,
+// as in POSIX. In interactive mode, they're also reported to the user.
+func (h *callHandler) fileResult(call *machine.Call, value uint64, err error) error {
+	if err != nil {
+		if h.interactive {
+			fmt.Fprintf(h.errOut, machine.InterCtx.Get("File call failed: %v\n"), err)
+		}
+		value = math.MaxUint64
+	}
+	return h.m.SetRegister(call.Return, h.truncate(value))
+}
+
+// Performs a call. Unknown calls are ignored.
+func (h *callHandler) handle(call *machine.Call) error {
+	switch call.Number {
+	case machine.SYS_READ:
+		return h.read(call.Arg1, call.Arg2)
+	case machine.SYS_WRITE:
+		if call.Arg2 == 0 {
+			return nil
+		}
+		buf, err := h.m.GetMemoryChunk(call.Arg1, call.Arg2)
+		if err != nil {
+			return err
+		}
+		_, err = h.out.Write(buf)
+		return err
+	case machine.SYS_PRINT_INT:
+		_, err := fmt.Fprint(h.out, h.signed(call.Arg1))
+		return err
+	case machine.SYS_PRINT_UINT:
+		_, err := fmt.Fprint(h.out, h.truncate(call.Arg1))
+		return err
+	case machine.SYS_READ_INT:
+		value, err := h.readInt()
+		if err != nil {
+			return err
+		}
+		return h.m.SetRegister(call.Return, h.truncate(value))
+	case machine.SYS_PRINT_STRING:
+		return h.printString(call.Arg1)
+	case machine.SYS_SBRK:
+		old := h.heap
+		h.heap = h.truncate(uint64(int64(h.heap) + h.signed(call.Arg1)))
+		return h.m.SetRegister(call.Return, old)
+	case machine.SYS_TIME:
+		now, err := h.inputValue(call.Number, func() uint64 {
+			return uint64(time.Now().UnixMilli())
+		})
+		if err != nil {
+			return err
+		}
+		return h.m.SetRegister(call.Return, h.truncate(now))
+	case machine.SYS_RANDOM:
+		random, err := h.inputValue(call.Number, rand.Uint64)
+		if err != nil {
+			return err
+		}
+		return h.m.SetRegister(call.Return, h.truncate(random))
+	case machine.SYS_PRINT_CHAR:
+		_, err := h.out.Write([]uint8{uint8(call.Arg1)})
+		return err
+	case machine.SYS_READ_CHAR:
+		e, err := h.input(call.Number, func() (inputEvent, error) {
+			b, err := h.in.ReadByte()
+			if err == io.EOF {
+				return inputEvent{EOF: true}, nil
+			}
+			if err != nil {
+				return inputEvent{}, fmt.Errorf(machine.InterCtx.Get("error reading input: %v"), err)
+			}
+			return inputEvent{Data: []uint8{b}}, nil
+		})
+		if err != nil {
+			return err
+		}
+		if e.EOF || len(e.Data) == 0 {
+			return h.m.SetRegister(call.Return, h.truncate(math.MaxUint64))
+		}
+		return h.m.SetRegister(call.Return, uint64(e.Data[0]))
+	case machine.SYS_READ_STRING:
+		return h.readString(call.Arg1, call.Arg2)
+	case machine.SYS_OPEN:
+		fd, err := h.open(call.Arg1, call.Arg2)
+		return h.fileResult(call, fd, err)
+	case machine.SYS_READ_FD:
+		n, err := h.readFd(call.Arg1, call.Arg2, call.Arg3)
+		return h.fileResult(call, n, err)
+	case machine.SYS_WRITE_FD:
+		n, err := h.writeFd(call.Arg1, call.Arg2, call.Arg3)
+		return h.fileResult(call, n, err)
+	case machine.SYS_CLOSE:
+		ret, err := h.closeFd(call.Arg1)
+		return h.fileResult(call, ret, err)
+	case machine.SYS_LSEEK:
+		pos, err := h.lseek(call.Arg1, h.signed(call.Arg2), call.Arg3)
+		return h.fileResult(call, pos, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gboncoffee/egg/machine"
+	"github.com/gboncoffee/egg/sagui"
+)
+
+func TestSandbox(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	h := callHandler{fsRoot: root}
+
+	err := os.WriteFile(filepath.Join(root, "file"), []byte("foo"), 0644)
+	if err != nil {
+		t.Fatalf("Error writing file: %v", err)
+	}
+	err = os.Symlink("file", filepath.Join(root, "link"))
+	if err != nil {
+		t.Fatalf("Error creating link: %v", err)
+	}
+	// Dangling link to a file outside of the root.
+	err = os.Symlink(filepath.Join(outside, "new"), filepath.Join(root, "dangling"))
+	if err != nil {
+		t.Fatalf("Error creating link: %v", err)
+	}
+
+	for _, name := range []string{"file", "/file", "../file", "link"} {
+		f, err := h.openSandboxed(name, os.O_RDONLY)
+		if err != nil {
+			t.Fatalf("Couldn't open %v: %v", name, err)
+		}
+		f.Close()
+	}
+
+	f, err := h.openSandboxed("dangling", os.O_WRONLY|os.O_CREATE)
+	if err == nil {
+		f.Close()
+		t.Fatalf("Opened a link to outside of the root")
+	}
+	if _, err := os.Lstat(filepath.Join(outside, "new")); err == nil {
+		t.Fatalf("Created a file outside of the root")
+	}
+}
+
+func TestEmptyCalls(t *testing.T) {
+	machine.InterCtx.Init()
+
+	var m sagui.Sagui
+	var out bytes.Buffer
+	h := newCallHandler(&m, strings.NewReader("foo"), &out, 0)
+
+	calls := []machine.Call{
+		{Number: machine.SYS_READ},
+		{Number: machine.SYS_WRITE},
+		{Number: machine.SYS_READ_FD, Arg1: STDIN_FD, Return: 1},
+		{Number: machine.SYS_WRITE_FD, Arg1: STDOUT_FD, Return: 1},
+	}
+	for _, call := range calls {
+		_ = m.SetRegister(1, 42)
+		err := h.handle(&call)
+		if err != nil {
+			t.Fatalf("Call %v of 0 bytes failed: %v", call.Number, err)
+		}
+		if call.Return != 0 {
+			if r, _ := m.GetRegister(1); r != 0 {
+				t.Fatalf("Call %v of 0 bytes returned %v", call.Number, r)
+			}
+		}
+	}
+	if out.Len() != 0 {
+		t.Fatalf("Calls of 0 bytes wrote %q", out.String())
+	}
+}
+
+func TestLargeReads(t *testing.T) {
+	machine.InterCtx.Init()
+
+	// Sizes past the end of the address space are clamped instead of
+	// allocated.
+	calls := []machine.Call{
+		{Number: machine.SYS_READ, Arg1: 0xf0, Arg2: 0xffffffff},
+		{Number: machine.SYS_READ_FD, Arg1: STDIN_FD, Arg2: 0xf0, Arg3: 0xffffffff, Return: 1},
+	}
+	for _, call := range calls {
+		var m sagui.Sagui
+		_ = m.SetMemory(0xf5, 42)
+		h := newCallHandler(&m, strings.NewReader("abc"), &bytes.Buffer{}, 0)
+
+		err := h.handle(&call)
+		if err != nil {
+			t.Fatalf("Call %v failed: %v", call.Number, err)
+		}
+		read, _ := m.GetMemoryChunk(0xf0, 6)
+		if string(read[:3]) != "abc" {
+			t.Fatalf("Call %v read %q", call.Number, read)
+		}
+		if call.Number == machine.SYS_READ && read[5] != 0 {
+			t.Fatalf("READ didn't zero the rest of the buffer")
+		}
+		if r, _ := m.GetRegister(1); call.Return != 0 && r != 3 {
+			t.Fatalf("Call %v returned %v", call.Number, r)
+		}
+	}
+}
+
+func TestTTY(t *testing.T) {
+	machine.InterCtx.Init()
+
+	name := filepath.Join(t.TempDir(), "file")
+	err := os.WriteFile(name, []byte("foo"), 0644)
+	if err != nil {
+		t.Fatalf("Error writing file: %v", err)
+	}
+
+	var m sagui.Sagui
+	h := newCallHandler(&m, strings.NewReader(""), io.Discard, 0)
+	if err := h.setTTY(name); err == nil {
+		t.Fatalf("Regular file accepted as a tty")
+	}
+	content, err := os.ReadFile(name)
+	if err != nil || string(content) != "foo" {
+		t.Fatalf("File changed to %q (%v)", content, err)
+	}
+
+	if err := h.setTTY(os.DevNull); err != nil {
+		t.Fatalf("Couldn't use %v as a tty: %v", os.DevNull, err)
+	}
+	if h.inFile == nil || h.outFile == nil {
+		t.Fatalf("Input and output not redirected")
+	}
+	if err := h.setTTY(""); err != nil || h.inFile != nil || h.outFile != nil {
+		t.Fatalf("Input and output not restored to the console (%v)", err)
+	}
+}
+
+func TestCalls(t *testing.T) {
+	machine.InterCtx.Init()
+
+	// Sagui words have 8 bits. Every call returns in r1, and strings are
+	// read to 0x10.
+	tests := []struct {
+		name   string
+		input  string
+		call   machine.Call
+		output string
+		ret    uint64
+		mem    []uint8
+		err    bool
+	}{
+		{"print int", "", machine.Call{Number: machine.SYS_PRINT_INT, Arg1: 0xff}, "-1", 0, nil, false},
+		{"print uint", "", machine.Call{Number: machine.SYS_PRINT_UINT, Arg1: 0x1ff}, "255", 0, nil, false},
+		{"print char", "", machine.Call{Number: machine.SYS_PRINT_CHAR, Arg1: 'a'}, "a", 0, nil, false},
+		{"print string", "", machine.Call{Number: machine.SYS_PRINT_STRING, Arg1: 0x20}, "hi", 0, nil, false},
+		{"read int", " 42 \n", machine.Call{Number: machine.SYS_READ_INT}, "", 42, nil, false},
+		{"read negative int", "-1\n", machine.Call{Number: machine.SYS_READ_INT}, "", 0xff, nil, false},
+		{"read hex int", "0x10", machine.Call{Number: machine.SYS_READ_INT}, "", 0x10, nil, false},
+		{"read big int", "300\n", machine.Call{Number: machine.SYS_READ_INT}, "", 44, nil, false},
+		{"read bad int", "foo\n", machine.Call{Number: machine.SYS_READ_INT}, "", 0, nil, true},
+		{"read no int", "", machine.Call{Number: machine.SYS_READ_INT}, "", 0, nil, true},
+		{"read string", "hello\n", machine.Call{Number: machine.SYS_READ_STRING, Arg1: 0x10, Arg2: 8}, "", 0, []uint8("hello\n\x00"), false},
+		{"read long string", "hello\n", machine.Call{Number: machine.SYS_READ_STRING, Arg1: 0x10, Arg2: 3}, "", 0, []uint8("he\x00"), false},
+		{"read char", "xy", machine.Call{Number: machine.SYS_READ_CHAR}, "", 'x', nil, false},
+		{"read char at the end", "", machine.Call{Number: machine.SYS_READ_CHAR}, "", 0xff, nil, false},
+		{"sbrk", "", machine.Call{Number: machine.SYS_SBRK, Arg1: 4}, "", 0x40, nil, false},
+	}
+	for _, test := range tests {
+		var m sagui.Sagui
+		_ = m.SetMemoryChunk(0x20, []uint8("hi\x00"))
+		var out bytes.Buffer
+		h := newCallHandler(&m, strings.NewReader(test.input), &out, 0x40)
+
+		test.call.Return = 1
+		err := h.handle(&test.call)
+		if (err != nil) != test.err {
+			t.Fatalf("%v: got error %v", test.name, err)
+		}
+		ret, _ := m.GetRegister(1)
+		mem, _ := m.GetMemoryChunk(0x10, uint64(len(test.mem)))
+		if out.String() != test.output || ret != test.ret || !bytes.Equal(mem, test.mem) {
+			t.Fatalf("%v: got output %q, r1 %v and memory %q", test.name, out.String(), ret, mem)
+		}
+	}
+
+	// The heap grows and shrinks, with each call returning the old end.
+	var m sagui.Sagui
+	h := newCallHandler(&m, strings.NewReader(""), io.Discard, 0x40)
+	for _, sbrk := range []struct {
+		increment uint64
+		old       uint64
+	}{{4, 0x40}, {0xfc, 0x44}, {0, 0x40}} {
+		call := machine.Call{Number: machine.SYS_SBRK, Arg1: sbrk.increment, Return: 1}
+		_ = h.handle(&call)
+		if ret, _ := m.GetRegister(1); ret != sbrk.old {
+			t.Fatalf("sbrk %v returned 0x%x, expected 0x%x", int8(sbrk.increment), ret, sbrk.old)
+		}
+	}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	machine.InterCtx.Init()
+
+	// Data is base64, as encoding/json writes byte slices.
+	recorded := `{"call":3,"data":"YWIK"}
+{"call":13,"value":42}
+{"call":14,"eof":true}
+`
+	var l inputLog
+	err := l.load(strings.NewReader(recorded))
+	if err != nil {
+		t.Fatalf("Error loading events: %v", err)
+	}
+	expected := []inputEvent{
+		{Call: 3, Data: []uint8("ab\n")},
+		{Call: 13, Value: 42},
+		{Call: 14, EOF: true},
+	}
+	for _, e := range expected {
+		got, ok, err := l.replay(e.Call)
+		if !ok || err != nil || got.Call != e.Call || !bytes.Equal(got.Data, e.Data) || got.Value != e.Value || got.EOF != e.EOF {
+			t.Fatalf("Replayed %v (%v, %v), expected %v", got, ok, err, e)
+		}
+	}
+	if _, ok, _ := l.replay(3); ok {
+		t.Fatalf("Replayed past the last event")
+	}
+
+	// Recording writes the events already loaded and the new ones in the
+	// same format.
+	var out bytes.Buffer
+	err = l.recordTo(&out)
+	if err != nil {
+		t.Fatalf("Error recording: %v", err)
+	}
+	err = l.add(inputEvent{Call: 15, Value: 7})
+	if err != nil {
+		t.Fatalf("Error recording: %v", err)
+	}
+	if out.String() != recorded+`{"call":15,"value":7}`+"\n" {
+		t.Fatalf("Wrong record:\n%v", out.String())
+	}
+
+	// A different call stops replaying, and the events after it are
+	// forgotten.
+	l.rewind()
+	if _, ok, err := l.replay(13); ok || err == nil || len(l.events) != 0 {
+		t.Fatalf("Replayed event of another call: %v, %v", ok, err)
+	}
+
+	for _, malformed := range []string{"{", `{"call":"foo"}`, "42"} {
+		var l inputLog
+		if err := l.load(strings.NewReader(malformed)); err == nil {
+			t.Fatalf("Malformed file %q loaded", malformed)
+		}
+	}
+}
@@ -1,13 +1,16 @@
 package main
 
 import (
-	"bufio"
 	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"unicode"
 
 	"github.com/gboncoffee/egg/assembler"
@@ -16,7 +19,16 @@ import (
 
 // TODO: information on every sysbreak/breakpoint operation.
 
+// Maximum number of instructions that can be undone with back and
+// reverse-continue.
+const JOURNAL_LIMIT = 1 << 16
+
 type Breakpoint struct {
+	// Number used to refer to the breakpoint in commands. Never reused in a
+	// debugging session.
+	ID int
+	// Disabled breakpoints are kept but never stop the execution.
+	Enabled bool
 	// nil if no file.
 	File *string
 	// Empty if no label
@@ -25,10 +37,320 @@ type Breakpoint struct {
 	Line int
 	// This one always exists.
 	Address uint64
+	// nil if the breakpoint is unconditional.
+	Condition *Condition
+	// Number of times the breakpoint was reached with it's condition true.
+	Hits uint64
+	// Number of times the breakpoint should still be ignored.
+	Ignore uint64
+	// As typed by the user, so the breakpoint can be resolved again after the
+	// Assembly is reloaded.
+	Expr string
+}
+
+// Operand of a breakpoint condition: a register, a constant or the word in
+// memory at [<register>+<offset>], [<register>-<offset>] or [<address>].
+type conditionOperand struct {
+	register bool
+	memory   bool
+	// Register number or constant value.
+	value  uint64
+	offset int64
+}
+
+type Condition struct {
+	lhs conditionOperand
+	op  string
+	rhs conditionOperand
+	// As typed by the user.
+	Expr string
+}
+
+// Operators sorted so that no operator is a prefix of the ones after it.
+var conditionOperators = []string{"==", "!=", "<=", ">=", "<", ">"}
+
+func parseConditionOperand(m machine.Machine, s string) (conditionOperand, error) {
+	if inner, ok := strings.CutPrefix(s, "["); ok {
+		inner, ok = strings.CutSuffix(inner, "]")
+		if !ok {
+			return conditionOperand{}, fmt.Errorf(machine.InterCtx.Get("missing ] in %v"), s)
+		}
+
+		base, offset, found := inner, "0", false
+		sign := int64(1)
+		if i := strings.IndexAny(inner, "+-"); i > 0 {
+			base, offset, found = inner[:i], inner[i+1:], true
+			if inner[i] == '-' {
+				sign = -1
+			}
+		}
+		base = strings.TrimSpace(base)
+		offset = strings.TrimSpace(offset)
+
+		reg, err := m.GetRegisterNumber(base)
+		if err == nil {
+			off, err := strconv.ParseInt(offset, 0, 64)
+			if err != nil {
+				return conditionOperand{}, fmt.Errorf(machine.InterCtx.Get("%v is not a number"), offset)
+			}
+			return conditionOperand{memory: true, register: true, value: reg, offset: sign * off}, nil
+		}
+		if found {
+			return conditionOperand{}, fmt.Errorf(machine.InterCtx.Get("no such register: %v"), base)
+		}
+		addr, err := strconv.ParseUint(base, 0, 64)
+		if err != nil {
+			return conditionOperand{}, fmt.Errorf(machine.InterCtx.Get("%v is not a register or address"), base)
+		}
+		return conditionOperand{memory: true, offset: int64(addr)}, nil
+	}
+
+	reg, err := m.GetRegisterNumber(s)
+	if err == nil {
+		return conditionOperand{register: true, value: reg}, nil
+	}
+	v, err := strconv.ParseInt(s, 0, 64)
+	if err != nil {
+		u, err := strconv.ParseUint(s, 0, 64)
+		if err != nil {
+			return conditionOperand{}, fmt.Errorf(machine.InterCtx.Get("%v is not a register or number"), s)
+		}
+		v = int64(u)
+	}
+	return conditionOperand{value: uint64(v)}, nil
+}
+
+// Parses conditions as <operand> <operator> <operand>, with operators being
+// ==, !=, <, <=, > and >=.
+func parseCondition(m machine.Machine, expr string) (*Condition, error) {
+	for _, op := range conditionOperators {
+		lhs, rhs, found := strings.Cut(expr, op)
+		if !found {
+			continue
+		}
+
+		l, err := parseConditionOperand(m, strings.TrimSpace(lhs))
+		if err != nil {
+			return nil, err
+		}
+		r, err := parseConditionOperand(m, strings.TrimSpace(rhs))
+		if err != nil {
+			return nil, err
+		}
+		return &Condition{lhs: l, op: op, rhs: r, Expr: expr}, nil
+	}
+
+	return nil, fmt.Errorf(machine.InterCtx.Get("cannot parse %v as condition"), expr)
+}
+
+// Values are compared as signed numbers of the machine word width.
+func (o conditionOperand) eval(m machine.Machine, info *machine.ArchitectureInfo) (int64, error) {
+	v := o.value
+	if o.register {
+		var err error
+		v, err = m.GetRegister(o.value)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if o.memory {
+		if !o.register {
+			v = 0
+		}
+		mem, err := m.GetMemoryChunk(v+uint64(o.offset), uint64(info.WordWidth/8))
+		if err != nil {
+			return 0, err
+		}
+		v = 0
+		for i, b := range mem {
+			v |= uint64(b) << (i * 8)
+		}
+	}
+
+	shift := 64 - info.WordWidth
+	return int64(v<<shift) >> shift, nil
+}
+
+func (c *Condition) holds(m machine.Machine, info *machine.ArchitectureInfo) (bool, error) {
+	if c == nil {
+		return true, nil
+	}
+
+	l, err := c.lhs.eval(m, info)
+	if err != nil {
+		return false, err
+	}
+	r, err := c.rhs.eval(m, info)
+	if err != nil {
+		return false, err
+	}
+
+	switch c.op {
+	case "==":
+		return l == r, nil
+	case "!=":
+		return l != r, nil
+	case "<":
+		return l < r, nil
+	case "<=":
+		return l <= r, nil
+	case ">":
+		return l > r, nil
+	default:
+		return l >= r, nil
+	}
+}
+
+type Watchpoint struct {
+	// If true, Address is a register number and Length is meaningless.
+	Register bool
+	// If true, stops when the location is read instead of written.
+	Read    bool
+	Address uint64
+	Length  uint64
+	// As typed by the user.
+	Expr string
+}
+
+func (wp Watchpoint) sameLocation(other Watchpoint) bool {
+	return wp.Register == other.Register && wp.Address == other.Address && wp.Length == other.Length
+}
+
+// A watchpoint triggered by the last instruction.
+type watchHit struct {
+	watchpoint Watchpoint
+	// Content of the register or memory before the instruction.
+	oldRegister uint64
+	oldMemory   []uint8
+}
+
+// Observer that checks watchpoints while the debugger executes instructions.
+// All methods may be called on a nil *watcher, in which case they do nothing.
+type watcher struct {
+	m           machine.Machine
+	watchpoints []Watchpoint
+	watching    bool
+	// Address of the instruction being executed.
+	pc   uint64
+	hits []watchHit
+}
+
+// Starts watching the execution of the instruction at pc.
+func (w *watcher) Begin(pc uint64) {
+	if w == nil {
+		return
+	}
+	w.pc = pc
+	w.hits = w.hits[:0]
+	w.watching = len(w.watchpoints) > 0
+}
+
+// Stops watching, so writes by the debugger itself don't trigger watchpoints.
+func (w *watcher) End() {
+	if w == nil {
+		return
+	}
+	w.watching = false
+}
+
+func (w *watcher) hit(wp Watchpoint, oldRegister uint64) {
+	for _, h := range w.hits {
+		if h.watchpoint == wp {
+			return
+		}
+	}
+
+	h := watchHit{watchpoint: wp, oldRegister: oldRegister}
+	if !wp.Register {
+		// Notifications are sent before writing, so memory still has the
+		// old content.
+		mem, _ := w.m.GetMemoryChunk(wp.Address, wp.Length)
+		h.oldMemory = slices.Clone(mem)
+	}
+	w.hits = append(w.hits, h)
+}
+
+func (w *watcher) OnMemoryRead(addr uint64, size uint64) {
+	if w == nil || !w.watching {
+		return
+	}
+	for _, wp := range w.watchpoints {
+		if wp.Read && !wp.Register && addr < wp.Address+wp.Length && wp.Address < addr+size {
+			w.hit(wp, 0)
+		}
+	}
+}
+
+func (w *watcher) OnMemoryWrite(addr uint64, old uint8, new uint8) {
+	if w == nil || !w.watching {
+		return
+	}
+	for _, wp := range w.watchpoints {
+		if !wp.Read && !wp.Register && wp.Address <= addr && addr < wp.Address+wp.Length {
+			w.hit(wp, 0)
+		}
+	}
+}
+
+func (w *watcher) OnRegisterWrite(reg uint64, old uint64, new uint64) {
+	if w == nil || !w.watching {
+		return
+	}
+	for _, wp := range w.watchpoints {
+		if wp.Register && wp.Address == reg {
+			w.hit(wp, old)
+		}
+	}
+}
+
+// Prints the watchpoints triggered by the last instruction. Returns false if
+// none was.
+func printWatchHits(m machine.Machine, sym []assembler.DebuggerToken, w *watcher, info *machine.ArchitectureInfo) bool {
+	if w == nil || len(w.hits) == 0 {
+		return false
+	}
+
+	for _, h := range w.hits {
+		wp := h.watchpoint
+		if wp.Read {
+			fmt.Printf(machine.InterCtx.Get("Watchpoint %v read by:\n"), wp.Expr)
+		} else {
+			fmt.Printf(machine.InterCtx.Get("Watchpoint %v written by:\n"), wp.Expr)
+		}
+		debuggerPrint(m, sym, []string{fmt.Sprintf("0x%x#1", w.pc)}, info)
+
+		if wp.Register {
+			v, _ := m.GetRegister(wp.Address)
+			fmt.Printf(machine.InterCtx.Get("Old value: 0x%x\n"), h.oldRegister)
+			fmt.Printf(machine.InterCtx.Get("New value: 0x%x\n"), v)
+			continue
+		}
+
+		mem, _ := getMemoryContentPrint(m, strconv.FormatUint(wp.Address, 10), strconv.FormatUint(wp.Length, 10))
+		if wp.Read {
+			fmt.Println(machine.InterCtx.Get("Value:"))
+			printMemory(mem)
+			continue
+		}
+		old := make([]uint64, len(h.oldMemory))
+		for i, v := range h.oldMemory {
+			old[i] = uint64(v)
+		}
+		fmt.Println(machine.InterCtx.Get("Old value:"))
+		printMemory(old)
+		fmt.Println(machine.InterCtx.Get("New value:"))
+		printMemory(mem)
+	}
+
+	return true
 }
 
 func breakpoint2String(breakpoint Breakpoint) string {
 	var s strings.Builder
+	// Zero while the breakpoint is not created yet.
+	if breakpoint.ID != 0 {
+		fmt.Fprintf(&s, "#%v ", breakpoint.ID)
+	}
 	if breakpoint.File != nil {
 		s.WriteString(*breakpoint.File)
 		fmt.Fprintf(&s, ":%v ", breakpoint.Line)
@@ -37,6 +359,9 @@ func breakpoint2String(breakpoint Breakpoint) string {
 		fmt.Fprintf(&s, machine.InterCtx.Get("(Label %v) "), breakpoint.Label)
 	}
 	fmt.Fprintf(&s, "0x%x", breakpoint.Address)
+	if breakpoint.Condition != nil {
+		fmt.Fprintf(&s, " if %v", breakpoint.Condition.Expr)
+	}
 
 	return s.String()
 }
@@ -277,24 +602,39 @@ func debuggerPrintAll(m machine.Machine, info *machine.ArchitectureInfo) {
 	}
 }
 
-func ioCall(m machine.Machine, call *machine.Call, in *bufio.Reader) {
-	if call.Number == machine.SYS_READ {
-		addr := call.Arg1
-		size := call.Arg2
-		fmt.Printf(machine.InterCtx.Get("READ call for address 0x%x with %d bytes:\n"), addr, size)
-		buf := make([]byte, size)
-		_, err := in.Read(buf)
-		if err != nil {
-			fmt.Printf(machine.InterCtx.Get("Error reading stdin: %v\n"), err)
-			return
+// Performs a call. Undoing it with the journal also undoes the changes to the
+// call handler.
+func ioCall(calls *callHandler, journal *machine.Journal, call *machine.Call) {
+	prompt := ""
+	switch call.Number {
+	case machine.SYS_READ:
+		prompt = fmt.Sprintf(machine.InterCtx.Get("READ call for address 0x%x with %d bytes:\n"), call.Arg1, call.Arg2)
+	case machine.SYS_READ_FD:
+		if call.Arg1 == STDIN_FD {
+			prompt = fmt.Sprintf(machine.InterCtx.Get("READ call for address 0x%x with %d bytes:\n"), call.Arg2, call.Arg3)
+		}
+	case machine.SYS_READ_INT:
+		prompt = machine.InterCtx.Get("READ_INT call:") + "\n"
+	case machine.SYS_READ_CHAR:
+		prompt = machine.InterCtx.Get("READ_CHAR call:") + "\n"
+	case machine.SYS_READ_STRING:
+		prompt = fmt.Sprintf(machine.InterCtx.Get("READ_STRING call for address 0x%x with %d bytes:\n"), call.Arg1, call.Arg2)
+	}
+	if prompt != "" {
+		if calls.inputs.replaying() {
+			fmt.Println(machine.InterCtx.Get("Replaying recorded input."))
+		} else {
+			fmt.Print(prompt)
 		}
+	}
 
-		_ = m.SetMemoryChunk(addr, []uint8(buf))
-	} else {
-		addr := call.Arg1
-		size := call.Arg2
-		buf, _ := m.GetMemoryChunk(addr, size)
-		fmt.Print(string(buf))
+	state := calls.state()
+	journal.OnUndo(func() {
+		calls.restore(state)
+	})
+	err := calls.handle(call)
+	if err != nil {
+		fmt.Printf(machine.InterCtx.Get("Error performing call: %v\n"), err)
 	}
 }
 
@@ -318,7 +658,42 @@ func printRegisters(m machine.Machine, info *machine.ArchitectureInfo, regs []ui
 	return regs
 }
 
-func debuggerNext(m machine.Machine, sym []assembler.DebuggerToken, in *bufio.Reader, info *machine.ArchitectureInfo, regs []uint64) []uint64 {
+// Counts a hit of the enabled breakpoint at pc, if any and it's condition
+// holds. Returns the breakpoint if the execution should stop at it, i.e., it's
+// not being ignored or it's condition cannot be evaluated.
+func reachBreakpoint(m machine.Machine, breakpoints []Breakpoint, pc uint64, info *machine.ArchitectureInfo) *Breakpoint {
+	var breakpoint *Breakpoint
+	for i, b := range breakpoints {
+		if b.Address == pc && b.Enabled {
+			breakpoint = &breakpoints[i]
+		}
+	}
+	if breakpoint == nil {
+		return nil
+	}
+
+	holds, err := breakpoint.Condition.holds(m, info)
+	if err != nil {
+		fmt.Printf(machine.InterCtx.Get("Cannot evaluate condition of breakpoint %v: %v\n"), breakpoint2String(*breakpoint), err)
+		return breakpoint
+	}
+	if !holds {
+		return nil
+	}
+	breakpoint.Hits++
+	if breakpoint.Ignore > 0 {
+		breakpoint.Ignore--
+		return nil
+	}
+	return breakpoint
+}
+
+func debuggerNext(m machine.Machine, sym []assembler.DebuggerToken, breakpoints []Breakpoint, journal *machine.Journal, w *watcher, calls *callHandler, info *machine.ArchitectureInfo, regs []uint64) []uint64 {
+	journal.Begin(m.GetCurrentInstructionAddress())
+	defer journal.End()
+	w.Begin(m.GetCurrentInstructionAddress())
+	defer w.End()
+
 	call, err := m.NextInstruction()
 	if err != nil {
 		fmt.Printf(machine.InterCtx.Get("Instruction execution failed: %v\n"), err)
@@ -329,10 +704,16 @@ func debuggerNext(m machine.Machine, sym []assembler.DebuggerToken, in *bufio.Re
 	if call != nil {
 		if call.Number == machine.SYS_BREAK {
 			fmt.Printf(machine.InterCtx.Get("BREAK call while stepping at address 0x%x\n"), pc)
+		} else if call.Number == machine.SYS_EXIT {
+			fmt.Printf(machine.InterCtx.Get("Program exited with status %v\n"), uint8(call.Arg1))
 		} else {
-			ioCall(m, call, in)
+			ioCall(calls, journal, call)
 		}
 	}
+	printWatchHits(m, sym, w, info)
+	if breakpoint := reachBreakpoint(m, breakpoints, pc, info); breakpoint != nil {
+		fmt.Printf(machine.InterCtx.Get("Stopped at breakpoint: %v\n"), breakpoint2String(*breakpoint))
+	}
 
 	debuggerPrint(m, sym, []string{"#3"}, info)
 
@@ -393,8 +774,21 @@ func parseBreakpoint(arg string, sym []assembler.DebuggerToken) (Breakpoint, err
 	return Breakpoint{}, fmt.Errorf(machine.InterCtx.Get("cannot parse %v as breakpoint"), arg)
 }
 
-func debuggerContinue(m machine.Machine, sym []assembler.DebuggerToken, breakpoints []Breakpoint, in *bufio.Reader, info *machine.ArchitectureInfo, regs []uint64) []uint64 {
+func debuggerContinue(m machine.Machine, sym []assembler.DebuggerToken, breakpoints []Breakpoint, journal *machine.Journal, w *watcher, calls *callHandler, interrupted *atomic.Bool, info *machine.ArchitectureInfo, regs []uint64) []uint64 {
+	defer journal.End()
+	defer w.End()
+
+	// Ignore interrupts received while in the prompt.
+	interrupted.Store(false)
 	for {
+		if interrupted.Swap(false) {
+			fmt.Printf(machine.InterCtx.Get("Interrupted at address 0x%x\n"), m.GetCurrentInstructionAddress())
+			debuggerPrint(m, sym, []string{"#3"}, info)
+			return printRegisters(m, info, regs)
+		}
+
+		journal.Begin(m.GetCurrentInstructionAddress())
+		w.Begin(m.GetCurrentInstructionAddress())
 		call, err := m.NextInstruction()
 		if err != nil {
 			fmt.Printf(machine.InterCtx.Get("Instruction execution failed: %v\n"), err)
@@ -407,21 +801,21 @@ func debuggerContinue(m machine.Machine, sym []assembler.DebuggerToken, breakpoi
 				fmt.Printf(machine.InterCtx.Get("Stopped at BREAK call at address 0x%x\n"), pc)
 				debuggerPrint(m, sym, []string{"#3"}, info)
 				return printRegisters(m, info, regs)
+			} else if call.Number == machine.SYS_EXIT {
+				fmt.Printf(machine.InterCtx.Get("Program exited with status %v\n"), uint8(call.Arg1))
+				debuggerPrint(m, sym, []string{"#3"}, info)
+				return printRegisters(m, info, regs)
 			} else {
-				ioCall(m, call, in)
+				ioCall(calls, journal, call)
 			}
 		}
 
-		brk := false
-		var breakpoint *Breakpoint
-		for i, b := range breakpoints {
-			if b.Address == pc {
-				brk = true
-				breakpoint = &breakpoints[i]
-			}
+		if printWatchHits(m, sym, w, info) {
+			debuggerPrint(m, sym, []string{"#3"}, info)
+			return printRegisters(m, info, regs)
 		}
 
-		if brk {
+		if breakpoint := reachBreakpoint(m, breakpoints, pc, info); breakpoint != nil {
 			fmt.Printf(machine.InterCtx.Get("Stopped at breakpoint: %v\n"), breakpoint2String(*breakpoint))
 			debuggerPrint(m, sym, []string{"#3"}, info)
 			return printRegisters(m, info, regs)
@@ -429,14 +823,166 @@ func debuggerContinue(m machine.Machine, sym []assembler.DebuggerToken, breakpoi
 	}
 }
 
+func printWatchpoints(w *watcher) {
+	fmt.Println(machine.InterCtx.Get("Watchpoints:"))
+	for _, wp := range w.watchpoints {
+		if wp.Read {
+			fmt.Printf(machine.InterCtx.Get("%v (read)\n"), wp.Expr)
+		} else {
+			fmt.Println(wp.Expr)
+		}
+	}
+}
+
+func parseWatchpoint(m machine.Machine, arg string, read bool) (Watchpoint, error) {
+	addr, length, err := getSetExpr(m, arg)
+	if err != nil {
+		return Watchpoint{}, err
+	}
+
+	if !strings.ContainsRune(arg, '@') {
+		if read {
+			return Watchpoint{}, errors.New(machine.InterCtx.Get("only memory can be watched for reads"))
+		}
+		return Watchpoint{Register: true, Address: addr, Expr: arg}, nil
+	}
+	if length == 0 {
+		return Watchpoint{}, errors.New(machine.InterCtx.Get("cannot watch 0 bytes"))
+	}
+	_, err = m.GetMemoryChunk(addr, length)
+	if err != nil {
+		return Watchpoint{}, err
+	}
+
+	return Watchpoint{Read: read, Address: addr, Length: length, Expr: arg}, nil
+}
+
+func debuggerWatch(m machine.Machine, w *watcher, args []string, read bool) {
+	if w == nil {
+		fmt.Println(machine.InterCtx.Get("Watchpoints are not supported for the selected backend."))
+		return
+	}
+	if len(args) < 1 {
+		printWatchpoints(w)
+		return
+	}
+
+	wp, err := parseWatchpoint(m, args[0], read)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+	// Expressions using registers are evaluated now, so use the address in
+	// the listing.
+	if !wp.Register {
+		wp.Expr = fmt.Sprintf("0x%x@%v", wp.Address, wp.Length)
+	}
+	if slices.ContainsFunc(w.watchpoints, func(other Watchpoint) bool {
+		return other.Read == wp.Read && other.sameLocation(wp)
+	}) {
+		fmt.Println(machine.InterCtx.Get("Watchpoint already exists"))
+		return
+	}
+
+	w.watchpoints = append(w.watchpoints, wp)
+	fmt.Printf(machine.InterCtx.Get("New watchpoint %v\n"), wp.Expr)
+}
+
+func debuggerUnwatch(m machine.Machine, w *watcher, args []string) {
+	if w == nil {
+		fmt.Println(machine.InterCtx.Get("Watchpoints are not supported for the selected backend."))
+		return
+	}
+	if len(args) < 1 {
+		fmt.Println(machine.InterCtx.Get("unwatch expects a watchpoint to remove: unwatch <expr>"))
+		return
+	}
+
+	wp, err := parseWatchpoint(m, args[0], false)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+
+	// Removes both the read and write watchpoints of the location.
+	n := len(w.watchpoints)
+	w.watchpoints = slices.DeleteFunc(w.watchpoints, wp.sameLocation)
+	if len(w.watchpoints) == n {
+		fmt.Printf(machine.InterCtx.Get("No watchpoint %v\n"), args[0])
+	}
+}
+
+func debuggerBack(m machine.Machine, sym []assembler.DebuggerToken, journal *machine.Journal, args []string, info *machine.ArchitectureInfo, regs []uint64) []uint64 {
+	if journal == nil {
+		fmt.Println(machine.InterCtx.Get("Reverse execution is not supported for the selected backend."))
+		return regs
+	}
+
+	count := uint64(1)
+	if len(args) > 0 {
+		var err error
+		count, err = strconv.ParseUint(args[0], 0, 64)
+		if err != nil {
+			fmt.Printf(machine.InterCtx.Get("%v is not a number.\n"), args[0])
+			return regs
+		}
+	}
+
+	for range count {
+		if !journal.Undo(m) {
+			fmt.Println(machine.InterCtx.Get("No more instructions to undo."))
+			break
+		}
+	}
+
+	debuggerPrint(m, sym, []string{"#3"}, info)
+	return printRegisters(m, info, regs)
+}
+
+func debuggerReverseContinue(m machine.Machine, sym []assembler.DebuggerToken, breakpoints []Breakpoint, journal *machine.Journal, info *machine.ArchitectureInfo, regs []uint64) []uint64 {
+	if journal == nil {
+		fmt.Println(machine.InterCtx.Get("Reverse execution is not supported for the selected backend."))
+		return regs
+	}
+
+	for journal.Undo(m) {
+		pc := m.GetCurrentInstructionAddress()
+		for _, b := range breakpoints {
+			if b.Address != pc || !b.Enabled {
+				continue
+			}
+			// Hit counts are not changed when running backwards.
+			if holds, err := b.Condition.holds(m, info); holds || err != nil {
+				fmt.Printf(machine.InterCtx.Get("Stopped at breakpoint: %v\n"), breakpoint2String(b))
+				debuggerPrint(m, sym, []string{"#3"}, info)
+				return printRegisters(m, info, regs)
+			}
+		}
+	}
+
+	fmt.Println(machine.InterCtx.Get("No more instructions to undo."))
+	debuggerPrint(m, sym, []string{"#3"}, info)
+	return printRegisters(m, info, regs)
+}
+
 func printBreakpoints(breakpoints []Breakpoint) {
 	fmt.Println(machine.InterCtx.Get("Breakpoints:"))
 	for _, b := range breakpoints {
-		fmt.Println(breakpoint2String(b))
+		fmt.Print(breakpoint2String(b))
+		if !b.Enabled {
+			fmt.Print(machine.InterCtx.Get(" (disabled)"))
+		}
+		if b.Hits > 0 {
+			fmt.Printf(machine.InterCtx.Get(" (hit %v times)"), b.Hits)
+		}
+		if b.Ignore > 0 {
+			fmt.Printf(machine.InterCtx.Get(" (ignoring next %v hits)"), b.Ignore)
+		}
+		fmt.Println()
 	}
 }
 
-func debuggerBreakpoint(sym []assembler.DebuggerToken, breakpoints *[]Breakpoint, args []string) {
+func debuggerBreakpoint(m machine.Machine, sym []assembler.DebuggerToken, breakpoints *[]Breakpoint, nextID *int, args []string) {
 	if len(args) < 1 {
 		printBreakpoints(*breakpoints)
 		return
@@ -448,6 +994,18 @@ func debuggerBreakpoint(sym []assembler.DebuggerToken, breakpoints *[]Breakpoint
 		return
 	}
 
+	if len(args) > 1 {
+		if args[1] != "if" || len(args) < 3 {
+			fmt.Println(machine.InterCtx.Get("break expects a condition after the breakpoint: break <expr> if <condition>"))
+			return
+		}
+		new.Condition, err = parseCondition(m, strings.Join(args[2:], " "))
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+
 	nBreakpoints := make([]Breakpoint, len(*breakpoints)+1)
 	var breakpointIdx int
 	for i, p := range *breakpoints {
@@ -455,13 +1013,23 @@ func debuggerBreakpoint(sym []assembler.DebuggerToken, breakpoints *[]Breakpoint
 			nBreakpoints[i] = p
 			breakpointIdx++
 		} else if p.Address == new.Address {
-			fmt.Println(machine.InterCtx.Get("Breakpoint already exists."))
+			if new.Condition == nil {
+				fmt.Println(machine.InterCtx.Get("Breakpoint already exists."))
+				return
+			}
+			(*breakpoints)[i].Condition = new.Condition
+			fmt.Printf(machine.InterCtx.Get("Changed condition of breakpoint %v\n"), breakpoint2String((*breakpoints)[i]))
+			printBreakpoints(*breakpoints)
 			return
 		} else {
 			break
 		}
 	}
 
+	new.Expr = args[0]
+	new.ID = *nextID
+	new.Enabled = true
+	*nextID++
 	nBreakpoints[breakpointIdx] = new
 	if breakpointIdx < len(*breakpoints) {
 		for i, p := range (*breakpoints)[breakpointIdx:] {
@@ -475,6 +1043,81 @@ func debuggerBreakpoint(sym []assembler.DebuggerToken, breakpoints *[]Breakpoint
 	printBreakpoints(*breakpoints)
 }
 
+// Finds a breakpoint by it's ID. Accepts both N and #N.
+func findBreakpoint(breakpoints []Breakpoint, arg string) (int, error) {
+	id, err := strconv.Atoi(strings.TrimPrefix(arg, "#"))
+	if err == nil {
+		for i, b := range breakpoints {
+			if b.ID == id {
+				return i, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf(machine.InterCtx.Get("no breakpoint number %v"), arg)
+}
+
+func debuggerIgnore(breakpoints []Breakpoint, args []string) {
+	if len(args) < 2 {
+		fmt.Println(machine.InterCtx.Get("ignore expects a breakpoint number and a count: ignore <number> <count>"))
+		return
+	}
+
+	i, err := findBreakpoint(breakpoints, args[0])
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	count, err := strconv.ParseUint(args[1], 0, 64)
+	if err != nil {
+		fmt.Printf(machine.InterCtx.Get("%v is not a number.\n"), args[1])
+		return
+	}
+
+	breakpoints[i].Ignore = count
+	fmt.Printf(machine.InterCtx.Get("Will ignore next %v hits of breakpoint %v\n"), count, breakpoint2String(breakpoints[i]))
+}
+
+func debuggerEnable(breakpoints []Breakpoint, args []string, enabled bool) {
+	if len(args) < 1 {
+		if enabled {
+			fmt.Println(machine.InterCtx.Get("enable expects breakpoint numbers: enable <number>..."))
+		} else {
+			fmt.Println(machine.InterCtx.Get("disable expects breakpoint numbers: disable <number>..."))
+		}
+		return
+	}
+
+	for _, arg := range args {
+		i, err := findBreakpoint(breakpoints, arg)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		breakpoints[i].Enabled = enabled
+	}
+
+	printBreakpoints(breakpoints)
+}
+
+func debuggerDelete(breakpoints *[]Breakpoint, args []string) {
+	if len(args) < 1 {
+		*breakpoints = []Breakpoint{}
+		fmt.Println(machine.InterCtx.Get("Deleted all breakpoints."))
+		return
+	}
+
+	for _, arg := range args {
+		i, err := findBreakpoint(*breakpoints, arg)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		*breakpoints = slices.Delete(*breakpoints, i, i+1)
+	}
+
+	printBreakpoints(*breakpoints)
+}
+
 func debuggerRemove(sym []assembler.DebuggerToken, breakpoints *[]Breakpoint, args []string) {
 	if len(args) < 1 {
 		fmt.Println(machine.InterCtx.Get("remove expects a breakpoint to remove: remove <address/label/file:line>"))
@@ -596,6 +1239,7 @@ func debuggerDump(m machine.Machine, args []string, prog []uint8) {
 }
 
 func debuggerRewind(m machine.Machine, prog []uint8) {
+	m.Reset()
 	err := m.LoadProgram(prog)
 	if err != nil {
 		fmt.Printf(machine.InterCtx.Get("Error while reloading machine: %v\n"), err)
@@ -604,7 +1248,55 @@ func debuggerRewind(m machine.Machine, prog []uint8) {
 	}
 }
 
-func debuggerReload(m machine.Machine, sym *[]assembler.DebuggerToken, breakpoints *[]Breakpoint, prog *[]uint8, fileName string) {
+// Resolves the breakpoints again against a new symbol table. Breakpoints created
+// from labels and <file>:<line> follow their instructions, and are deleted if
+// they cannot be found anymore. Breakpoints created from addresses are always
+// kept. If more than one breakpoint ends up at the same address, only the oldest
+// one is kept.
+func remapBreakpoints(breakpoints []Breakpoint, sym []assembler.DebuggerToken) []Breakpoint {
+	remapped := []Breakpoint{}
+	for _, b := range breakpoints {
+		new, err := parseBreakpoint(b.Expr, sym)
+		if err != nil {
+			fmt.Printf(machine.InterCtx.Get("Deleted breakpoint %v: %v\n"), breakpoint2String(b), err)
+			continue
+		}
+
+		new.ID = b.ID
+		new.Enabled = b.Enabled
+		new.Condition = b.Condition
+		new.Hits = b.Hits
+		new.Ignore = b.Ignore
+		new.Expr = b.Expr
+		if !slices.ContainsFunc(sym, func(t assembler.DebuggerToken) bool {
+			return t.Address == new.Address
+		}) {
+			fmt.Printf(machine.InterCtx.Get("Breakpoint %v does not map to an instruction anymore\n"), breakpoint2String(new))
+		} else if new.Address != b.Address {
+			fmt.Printf(machine.InterCtx.Get("Moved breakpoint %v to 0x%x\n"), breakpoint2String(b), new.Address)
+		}
+		remapped = append(remapped, new)
+	}
+
+	sort.SliceStable(remapped, func(i, j int) bool {
+		if remapped[i].Address != remapped[j].Address {
+			return remapped[i].Address < remapped[j].Address
+		}
+		return remapped[i].ID < remapped[j].ID
+	})
+
+	merged := []Breakpoint{}
+	for _, b := range remapped {
+		if len(merged) > 0 && merged[len(merged)-1].Address == b.Address {
+			fmt.Printf(machine.InterCtx.Get("Deleted breakpoint %v: same address as breakpoint #%v\n"), breakpoint2String(b), merged[len(merged)-1].ID)
+			continue
+		}
+		merged = append(merged, b)
+	}
+	return merged
+}
+
+func debuggerReload(m machine.Machine, sym *[]assembler.DebuggerToken, breakpoints *[]Breakpoint, checkpoints map[string]machine.Snapshot, prog *[]uint8, fileName string) {
 	newCode, newSym, err := m.Assemble(fileName)
 	if err != nil {
 		fmt.Println(machine.InterCtx.Get("Error assembling file:"))
@@ -613,21 +1305,160 @@ func debuggerReload(m machine.Machine, sym *[]assembler.DebuggerToken, breakpoin
 		return
 	}
 
+	m.Reset()
 	err = m.LoadProgram(newCode)
 	if err != nil {
 		fmt.Println(machine.InterCtx.Get("Error loading new assembled code:"))
 		fmt.Println(err)
-		fmt.Println(machine.InterCtx.Get("Keeping old program and program state."))
+		fmt.Println(machine.InterCtx.Get("Keeping old program."))
+		debuggerRewind(m, *prog)
+		return
 	}
 
 	*prog = newCode
 	*sym = newSym
-	*breakpoints = []Breakpoint{}
+	*breakpoints = remapBreakpoints(*breakpoints, newSym)
+	clear(checkpoints)
 
 	fmt.Println(machine.InterCtx.Get("Rebuild Assembly."))
 	fmt.Println(machine.InterCtx.Get("Reloaded machine."))
 }
 
+// Starts or stops watching the source files. stop is nil while not watching.
+func debuggerWatchFiles(args []string, stop *chan struct{}, fileName string, changed func()) {
+	if len(args) < 1 {
+		if *stop == nil {
+			fmt.Println(machine.InterCtx.Get("Not watching source files."))
+		} else {
+			fmt.Println(machine.InterCtx.Get("Watching source files."))
+		}
+		return
+	}
+
+	switch args[0] {
+	case "on":
+		if *stop != nil {
+			fmt.Println(machine.InterCtx.Get("Already watching source files."))
+			return
+		}
+		*stop = make(chan struct{})
+		go watchFiles(fileName, *stop, changed)
+		fmt.Println(machine.InterCtx.Get("Watching source files. The program will be reloaded when they change."))
+	case "off":
+		if *stop == nil {
+			fmt.Println(machine.InterCtx.Get("Not watching source files."))
+			return
+		}
+		close(*stop)
+		*stop = nil
+		fmt.Println(machine.InterCtx.Get("Stopped watching source files."))
+	default:
+		fmt.Println(machine.InterCtx.Get("Use watch-files on or watch-files off."))
+	}
+}
+
+func debuggerInput(calls *callHandler, args []string) {
+	name := ""
+	if len(args) > 0 {
+		name = args[0]
+	}
+
+	err := calls.setInput(name)
+	if err != nil {
+		fmt.Printf(machine.InterCtx.Get("Error redirecting input: %v\n"), err)
+	} else if name == "" {
+		fmt.Println(machine.InterCtx.Get("Program input from the console."))
+	} else {
+		fmt.Printf(machine.InterCtx.Get("Program input from %v\n"), name)
+	}
+}
+
+func debuggerOutput(calls *callHandler, args []string) {
+	name := ""
+	if len(args) > 0 {
+		name = args[0]
+	}
+
+	err := calls.setOutput(name)
+	if err != nil {
+		fmt.Printf(machine.InterCtx.Get("Error redirecting output: %v\n"), err)
+	} else if name == "" {
+		fmt.Println(machine.InterCtx.Get("Program output to the console."))
+	} else {
+		fmt.Printf(machine.InterCtx.Get("Program output to %v\n"), name)
+	}
+}
+
+func debuggerTTY(calls *callHandler, args []string) {
+	name := ""
+	if len(args) > 0 {
+		name = args[0]
+	}
+
+	err := calls.setTTY(name)
+	if err != nil {
+		fmt.Printf(machine.InterCtx.Get("Error redirecting input and output: %v\n"), err)
+	} else if name == "" {
+		fmt.Println(machine.InterCtx.Get("Program input from the console."))
+		fmt.Println(machine.InterCtx.Get("Program output to the console."))
+	} else {
+		fmt.Printf(machine.InterCtx.Get("Program input from %v\n"), name)
+		fmt.Printf(machine.InterCtx.Get("Program output to %v\n"), name)
+	}
+}
+
+func printCheckpoints(checkpoints map[string]machine.Snapshot) {
+	fmt.Println(machine.InterCtx.Get("Checkpoints:"))
+	names := make([]string, 0, len(checkpoints))
+	for name := range checkpoints {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("%v (0x%x)\n", name, checkpoints[name].PC)
+	}
+}
+
+func debuggerCheckpoint(m machine.Machine, checkpoints map[string]machine.Snapshot, args []string) {
+	if len(args) < 1 {
+		printCheckpoints(checkpoints)
+		return
+	}
+
+	snapshotter, ok := m.(machine.Snapshotter)
+	if !ok {
+		fmt.Println(machine.InterCtx.Get("Checkpoints are not supported for the selected backend."))
+		return
+	}
+
+	checkpoints[args[0]] = snapshotter.Snapshot()
+	fmt.Printf(machine.InterCtx.Get("New checkpoint %v at address 0x%x\n"), args[0], m.GetCurrentInstructionAddress())
+}
+
+func debuggerRestore(m machine.Machine, sym []assembler.DebuggerToken, checkpoints map[string]machine.Snapshot, args []string, info *machine.ArchitectureInfo, regs []uint64) []uint64 {
+	if len(args) < 1 {
+		fmt.Println(machine.InterCtx.Get("restore expects a checkpoint name: restore <name>"))
+		return regs
+	}
+
+	snapshot, ok := checkpoints[args[0]]
+	if !ok {
+		fmt.Printf(machine.InterCtx.Get("No checkpoint %v\n"), args[0])
+		return regs
+	}
+
+	// If the checkpoint exists, the machine surely is a Snapshotter.
+	err := m.(machine.Snapshotter).Restore(snapshot)
+	if err != nil {
+		fmt.Printf(machine.InterCtx.Get("Error while restoring checkpoint: %v\n"), err)
+		return regs
+	}
+
+	fmt.Printf(machine.InterCtx.Get("Restored checkpoint %v\n"), args[0])
+	debuggerPrint(m, sym, []string{"#3"}, info)
+	return printRegisters(m, info, regs)
+}
+
 // Returns length (second value) as 0 if it's a register.
 func getSetExpr(m machine.Machine, expr string) (uint64, uint64, error) {
 	addr, length, has_at := strings.Cut(expr, "@")
@@ -700,28 +1531,76 @@ func debuggerSet(m machine.Machine, args []string) {
 	}
 }
 
-func debugMachine(m machine.Machine, sym []assembler.DebuggerToken, prog []uint8, fileName string) {
+func debugMachine(m machine.Machine, calls *callHandler, sym []assembler.DebuggerToken, prog []uint8, fileName string) {
 	version()
 	fmt.Println(machine.InterCtx.Get("Type 'help' for a list of commands."))
 
 	info := m.ArchitectureInfo()
 	fmt.Println(machine.InterCtx.Get("Debugging"), info.Name)
 
+	// Rewinding and reloading replay the input.
+	calls.inputs.keep = true
+
 	var breakpoints []Breakpoint
-	in := bufio.NewReader(os.Stdin)
+	nextBreakpointID := 1
+	checkpoints := map[string]machine.Snapshot{}
+	var journal *machine.Journal
+	var w *watcher
+	if o, ok := m.(machine.Observable); ok {
+		journal = machine.NewJournal(JOURNAL_LIMIT)
+		w = &watcher{m: m}
+		o.SetObserver(machine.Observers{journal, w})
+	}
+	// Unless redirected, the program input is read from the console, as the
+	// commands.
+	in := calls.consoleIn
+	calls.interactive = true
 
 	regs := make([]uint64, len(info.RegistersNames))
 	for i := range info.RegistersNames {
 		regs[i], _ = m.GetRegister(uint64(i))
 	}
 
+	// SIGINT interrupts continue instead of killing the debugger.
+	var interrupted atomic.Bool
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	go func() {
+		for range interrupt {
+			interrupted.Store(true)
+		}
+	}()
+
+	reload := func() {
+		debuggerReload(m, &sym, &breakpoints, checkpoints, &prog, fileName)
+		journal.Clear()
+		calls.reset(uint64(len(prog)))
+	}
+
+	// While watching the source files, reloads happen from another goroutine,
+	// so every command holds this lock.
+	var lock sync.Mutex
+	var stopWatching chan struct{}
+	defer func() {
+		if stopWatching != nil {
+			close(stopWatching)
+		}
+	}()
+	filesChanged := func() {
+		lock.Lock()
+		defer lock.Unlock()
+		fmt.Println("")
+		fmt.Println(machine.InterCtx.Get("Source files changed."))
+		reload()
+		fmt.Print("egg> ")
+	}
+
 	fmt.Print("egg> ")
 	line, err := in.ReadString('\n')
 	for err == nil {
+		lock.Lock()
 		wsldirty := strings.Split(strings.TrimRight(line, "\n"), " ")
-		if len(wsldirty) == 0 {
-			continue
-		}
 		wsl := []string{}
 
 		for i := range wsldirty {
@@ -739,24 +1618,58 @@ func debugMachine(m machine.Machine, sym []assembler.DebuggerToken, prog []uint8
 			case "printall", "pall":
 				debuggerPrintAll(m, &info)
 			case "next", "n":
-				regs = debuggerNext(m, sym, in, &info, regs)
+				regs = debuggerNext(m, sym, breakpoints, journal, w, calls, &info, regs)
 			case "continue", "c":
-				regs = debuggerContinue(m, sym, breakpoints, in, &info, regs)
+				regs = debuggerContinue(m, sym, breakpoints, journal, w, calls, &interrupted, &info, regs)
+			case "back", "bk":
+				regs = debuggerBack(m, sym, journal, wsl[1:], &info, regs)
+			case "reverse-continue", "rc":
+				regs = debuggerReverseContinue(m, sym, breakpoints, journal, &info, regs)
 			case "break", "b":
-				debuggerBreakpoint(sym, &breakpoints, wsl[1:])
+				debuggerBreakpoint(m, sym, &breakpoints, &nextBreakpointID, wsl[1:])
+			case "ignore", "i":
+				debuggerIgnore(breakpoints, wsl[1:])
 			case "remove", "r":
 				debuggerRemove(sym, &breakpoints, wsl[1:])
+			case "enable", "en":
+				debuggerEnable(breakpoints, wsl[1:], true)
+			case "disable", "dis":
+				debuggerEnable(breakpoints, wsl[1:], false)
+			case "delete", "del":
+				debuggerDelete(&breakpoints, wsl[1:])
+			case "watch", "w":
+				debuggerWatch(m, w, wsl[1:], false)
+			case "rwatch", "rw":
+				debuggerWatch(m, w, wsl[1:], true)
+			case "unwatch", "uw":
+				debuggerUnwatch(m, w, wsl[1:])
 			case "dump", "d":
 				debuggerDump(m, wsl[1:], prog)
 			case "rewind", "rew":
 				debuggerRewind(m, prog)
+				journal.Clear()
+				calls.reset(uint64(len(prog)))
 			case "reload", "rel":
-				debuggerReload(m, &sym, &breakpoints, &prog, fileName)
+				reload()
+			case "watch-files", "wf":
+				debuggerWatchFiles(wsl[1:], &stopWatching, fileName, filesChanged)
+			case "input", "in":
+				debuggerInput(calls, wsl[1:])
+			case "output", "out":
+				debuggerOutput(calls, wsl[1:])
+			case "tty":
+				debuggerTTY(calls, wsl[1:])
 			case "set", "s":
 				debuggerSet(m, wsl[1:])
+			case "checkpoint", "cp":
+				debuggerCheckpoint(m, checkpoints, wsl[1:])
+			case "restore", "res":
+				regs = debuggerRestore(m, sym, checkpoints, wsl[1:], &info, regs)
+				journal.Clear()
 			case "exit", "e", "quit", "q":
 				fmt.Println("")
 				fmt.Println(machine.InterCtx.Get("bye!"))
+				lock.Unlock()
 				return
 			case "ping":
 				fmt.Println("pong!")
@@ -768,6 +1681,7 @@ func debugMachine(m machine.Machine, sym []assembler.DebuggerToken, prog []uint8
 		}
 
 		fmt.Print("egg> ")
+		lock.Unlock()
 		line, err = in.ReadString('\n')
 	}
 
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"io"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gboncoffee/egg/assembler"
+	"github.com/gboncoffee/egg/machine"
+	"github.com/gboncoffee/egg/sagui"
+)
+
+func TestReachBreakpoint(t *testing.T) {
+	breakpoints := []Breakpoint{
+		{Enabled: true, Address: 4, Ignore: 1},
+		{Enabled: false, Address: 8},
+	}
+
+	if b := reachBreakpoint(nil, breakpoints, 4, nil); b != nil {
+		t.Fatalf("stopped at ignored breakpoint")
+	}
+	if b := reachBreakpoint(nil, breakpoints, 4, nil); b != &breakpoints[0] {
+		t.Fatalf("didn't stop at breakpoint")
+	}
+	if breakpoints[0].Hits != 2 || breakpoints[0].Ignore != 0 {
+		t.Fatalf("wrong hits and ignore count: %v, %v", breakpoints[0].Hits, breakpoints[0].Ignore)
+	}
+
+	if b := reachBreakpoint(nil, breakpoints, 8, nil); b != nil || breakpoints[1].Hits != 0 {
+		t.Fatalf("disabled breakpoint was hit")
+	}
+}
+
+func TestUndoCall(t *testing.T) {
+	machine.InterCtx.Init()
+
+	var m sagui.Sagui
+	calls := newCallHandler(&m, strings.NewReader("ab"), io.Discard, 0)
+	calls.inputs.keep = true
+	journal := machine.NewJournal(8)
+	m.SetObserver(journal)
+
+	step := func(call machine.Call) uint64 {
+		journal.Begin(m.GetCurrentInstructionAddress())
+		ioCall(calls, journal, &call)
+		journal.End()
+		r, _ := m.GetRegister(call.Return)
+		return r
+	}
+	readChar := machine.Call{Number: machine.SYS_READ_CHAR, Return: 1}
+
+	// The input undone is read again, both after undoing and rewinding.
+	if c := step(readChar); c != 'a' {
+		t.Fatalf("Read %q, expected 'a'", rune(c))
+	}
+	journal.Undo(&m)
+	if c := step(readChar); c != 'a' {
+		t.Fatalf("Read %q after undoing, expected 'a'", rune(c))
+	}
+	calls.reset(0)
+	if c := step(readChar); c != 'a' {
+		t.Fatalf("Read %q after rewinding, expected 'a'", rune(c))
+	}
+	if c := step(readChar); c != 'b' {
+		t.Fatalf("Read %q, expected 'b'", rune(c))
+	}
+
+	heap := calls.heap
+	step(machine.Call{Number: machine.SYS_SBRK, Arg1: 16, Return: 1})
+	journal.Undo(&m)
+	if calls.heap != heap {
+		t.Fatalf("SBRK not undone: heap at 0x%x, expected 0x%x", calls.heap, heap)
+	}
+}
+
+func TestRemapBreakpoints(t *testing.T) {
+	machine.InterCtx.Init()
+
+	file := "main.s"
+	sym := []assembler.DebuggerToken{
+		{Label: "loop", File: &file, Line: 2, Address: 4},
+		{File: &file, Line: 3, Address: 8},
+	}
+	breakpoints := []Breakpoint{
+		{ID: 1, Enabled: true, Expr: "main.s:3", Address: 8},
+		{ID: 2, Enabled: true, Expr: "loop", Address: 12},
+		{ID: 3, Enabled: true, Expr: "0x10", Address: 16},
+		{ID: 4, Enabled: true, Expr: "gone", Address: 20},
+	}
+
+	// After the reload, the label is at line 3, so both breakpoints resolve to
+	// the same address.
+	sym[0].Line = 3
+	sym = sym[:1]
+	remapped := remapBreakpoints(breakpoints, sym)
+	if len(remapped) != 2 {
+		t.Fatalf("Expected 2 breakpoints, got %v", remapped)
+	}
+	if remapped[0].ID != 1 || remapped[0].Address != 4 {
+		t.Fatalf("Expected breakpoint #1 at 0x4, got %v", breakpoint2String(remapped[0]))
+	}
+	if remapped[1].ID != 3 || remapped[1].Address != 16 {
+		t.Fatalf("Expected breakpoint #3 at 0x10, got %v", breakpoint2String(remapped[1]))
+	}
+}
+
+func TestWatchpoints(t *testing.T) {
+	machine.InterCtx.Init()
+
+	var m sagui.Sagui
+	parsed := []struct {
+		expr     string
+		read     bool
+		expected Watchpoint
+		err      bool
+	}{
+		{"r1", false, Watchpoint{Register: true, Address: 1, Expr: "r1"}, false},
+		{"0x10@2", false, Watchpoint{Address: 0x10, Length: 2, Expr: "0x10@2"}, false},
+		{"0x20@4", true, Watchpoint{Read: true, Address: 0x20, Length: 4, Expr: "0x20@4"}, false},
+		{"r1", true, Watchpoint{}, true},
+		{"0x10@0", false, Watchpoint{}, true},
+		{"0xff@2", false, Watchpoint{}, true},
+		{"foo", false, Watchpoint{}, true},
+	}
+	w := watcher{m: &m}
+	for _, test := range parsed {
+		wp, err := parseWatchpoint(&m, test.expr, test.read)
+		if (err != nil) != test.err || wp != test.expected {
+			t.Fatalf("Parsed %v (read %v) as %v (%v)", test.expr, test.read, wp, err)
+		}
+		if err == nil {
+			w.watchpoints = append(w.watchpoints, wp)
+		}
+	}
+
+	_ = m.SetMemory(0x10, 5)
+	accesses := []struct {
+		name   string
+		access func()
+		hits   []string
+	}{
+		{"register write", func() { w.OnRegisterWrite(1, 3, 4) }, []string{"r1"}},
+		{"other register", func() { w.OnRegisterWrite(2, 3, 4) }, nil},
+		{"memory write", func() { w.OnMemoryWrite(0x11, 0, 1) }, []string{"0x10@2"}},
+		{"write after", func() { w.OnMemoryWrite(0x12, 0, 1) }, nil},
+		{"overlapping read", func() { w.OnMemoryRead(0x1e, 4) }, []string{"0x20@4"}},
+		{"read of written", func() { w.OnMemoryRead(0x10, 2) }, nil},
+		{"write of read", func() { w.OnMemoryWrite(0x20, 0, 1) }, nil},
+		{"twice", func() { w.OnMemoryWrite(0x10, 5, 6); w.OnMemoryWrite(0x11, 0, 1) }, []string{"0x10@2"}},
+	}
+	for _, test := range accesses {
+		w.Begin(0)
+		test.access()
+		w.End()
+		hits := []string{}
+		for _, h := range w.hits {
+			hits = append(hits, h.watchpoint.Expr)
+		}
+		if !slices.Equal(hits, test.hits) {
+			t.Fatalf("%v: hit %v, expected %v", test.name, hits, test.hits)
+		}
+	}
+
+	// The old content is kept, and nothing is watched outside of
+	// instructions.
+	w.Begin(0)
+	w.OnRegisterWrite(1, 3, 4)
+	w.OnMemoryWrite(0x10, 5, 6)
+	w.End()
+	if len(w.hits) != 2 || w.hits[0].oldRegister != 3 || !slices.Equal(w.hits[1].oldMemory, []uint8{5, 0}) {
+		t.Fatalf("Wrong old content: %v", w.hits)
+	}
+	w.Begin(0)
+	w.End()
+	w.OnRegisterWrite(1, 3, 4)
+	if len(w.hits) != 0 {
+		t.Fatalf("Watchpoint hit outside of an instruction")
+	}
+}
+
+func TestConditions(t *testing.T) {
+	machine.InterCtx.Init()
+
+	var m sagui.Sagui
+	info := m.ArchitectureInfo()
+	_ = m.SetRegister(1, 5)
+	_ = m.SetRegister(2, 0xff)
+	_ = m.SetMemory(0x10, 7)
+	_ = m.SetMemory(7, 9)
+	_ = m.SetMemory(3, 0x80)
+
+	tests := []struct {
+		expr  string
+		holds bool
+		err   bool
+	}{
+		{"r1 == 5", true, false},
+		{"r1==5", true, false},
+		{"r1 != 5", false, false},
+		{"r1 <= 5", true, false},
+		{"r1 >= 6", false, false},
+		{"r1 < r0", false, false},
+		// Sagui also names it's registers 0 to 3.
+		{"r1 > 3", true, false},
+		{"r1 > r0", true, false},
+		// Values are signed numbers of the word width.
+		{"r2 == -1", true, false},
+		{"r2 < r0", true, false},
+		{"[0x10] == 7", true, false},
+		{"[r1+2] == 9", true, false},
+		{"[r1-2] < 0", true, false},
+		{"[ r1 + 2 ] == 9", true, false},
+		{"r1", false, true},
+		{"r1 == foo", false, true},
+		{"[r1 == 5", false, true},
+		{"[foo+2] == 5", false, true},
+		{"[r1+x] == 5", false, true},
+		{"[0x100] == 0", false, true},
+	}
+	for _, test := range tests {
+		c, err := parseCondition(&m, test.expr)
+		var holds bool
+		if err == nil {
+			holds, err = c.holds(&m, &info)
+		}
+		if holds != test.holds || (err != nil) != test.err {
+			t.Errorf("%v: got %v (%v), expected %v", test.expr, holds, err, test.holds)
+		}
+	}
+
+	var unconditional *Condition
+	if holds, err := unconditional.holds(&m, &info); !holds || err != nil {
+		t.Fatalf("Missing condition doesn't hold")
+	}
+}
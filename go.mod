@@ -1,5 +1,5 @@
 module github.com/gboncoffee/egg
 
-go 1.22
+go 1.24
 
 require github.com/gboncoffee/intergo v1.0.1
@@ -15,27 +15,92 @@ next
 	Executes the next instruction, then pauses.
 	Shortcut: n
 continue
-	Continue execution until a BREAK call or breakpoint.
+	Continue execution until a BREAK call or breakpoint. Ctrl-C interrupts
+	the execution and returns to the prompt.
 	Shortcut: c
-break [expr]
+back [count]
+	Undoes the last executed instruction (or the last count instructions),
+	including memory written by READ calls. Undone calls get the same input,
+	time and random numbers when executed again, and SBRK is undone too, but
+	files opened, closed, read or written are not.
+	Shortcut: bk
+reverse-continue
+	Undoes instructions until a breakpoint is reached or there's nothing more
+	to undo.
+	Shortcut: rc
+break [expr] [if <condition>]
 	With an argument, creates a new breakpoint. With no argument, shows all
-	breakpoints. Accepts numbers, Assembly labels and <file>:<line>.
+	breakpoints with their numbers and hit counts. Accepts numbers, Assembly
+	labels and <file>:<line>. With a condition, the breakpoint only stops
+	when the condition is true. Adding a condition to an existing breakpoint
+	changes it's condition.
 	Shortcut: b
+ignore <number> <count>
+	Ignores the next count hits of a breakpoint.
+	Shortcut: i
+enable <number>...
+	Enables breakpoints.
+	Shortcut: en
+disable <number>...
+	Disables breakpoints, so they don't stop the execution but are kept.
+	Shortcut: dis
+delete [number]...
+	Deletes breakpoints by their numbers. With no argument, deletes all
+	breakpoints.
+	Shortcut: del
 remove <expr>
 	Removes a breakpoint. Accepts numbers and Assembly labels.
 	Shortcut: r
+watch [expr]
+	With an argument, creates a new watchpoint: continue stops when the
+	register or memory is written. With no argument, shows all watchpoints.
+	Accepts <register> and <expr>@<length>.
+	Shortcut: w
+rwatch <expr>@<length>
+	Same as watch, but stops when the memory is read.
+	Shortcut: rw
+unwatch <expr>
+	Removes the watchpoints of a register or memory.
+	Shortcut: uw
 dump <address>@<length> <filename>
 	Dumps the content of memory to a file.
 	Shortcut: d
 rewind
 	Reloads the machine, i.e., asks it to return to it's original state.
+	The input, time and random numbers got by the program are replayed.
 	Shortcut: rew
 reload
-	Reload the Assembly files and them reloads the machine.
+	Reload the Assembly files and them reloads the machine. Breakpoints
+	created from labels and <file>:<line> are moved to follow their
+	instructions.
 	Shortcut: rel
+watch-files [on|off]
+	Starts or stops watching the Assembly files (including the ones included
+	with .include). While watching, the program is reloaded as with reload
+	every time one of them is saved. With no argument, shows if the files
+	are being watched.
+	Shortcut: wf
+input [file]
+	Reads the program input from a file, which is read from the start again
+	on rewind and reload. With no argument, reads it from the console.
+	Shortcut: in
+output [file]
+	Writes the program output to a file. With no argument, writes it to the
+	console.
+	Shortcut: out
+tty [device]
+	Same as input and output with the same device, e.g., another terminal
+	(run 'tty' in it to find its device). Regular files are rejected.
 set <expr>[@<length>] <content>
 	Changes the content of a register or memory.
 	Shortcut: s
+checkpoint [name]
+	With an argument, saves the current state of the machine with that name.
+	With no argument, shows all checkpoints. Checkpoints are lost on reload.
+	Shortcut: cp
+restore <name>
+	Returns the machine to the state saved in a checkpoint.
+	Shortcut: res
 exit
 	Terminate debugging session.
 	Shortcut: e
@@ -50,6 +115,12 @@ The print command generally follows this rules:
   prints the content of the memory addressed by the content of the register.
 The set command works the same way.
 
+Breakpoint conditions are written as <operand> <operator> <operand>, with the
+operators ==, !=, <, <=, > and >=. Operands may be registers, numbers or a word
+of memory, written as [<register>+<offset>], [<register>-<offset>] or
+[<address>]. Values are compared as signed numbers, e.g., 'break loop if t0 ==
+10' or 'break 0x40 if [sp+4] > 3'.
+
 The dump command also accepts registers, but always dereference them.
 
 Both print and dump commands accepts the special expression #, which means the
@@ -76,23 +147,68 @@ var brazilian = map[string]string{
 'sagui' - RISC fantasia de 8 bits`,
 	"EGG - Emulador Genérico do Gabriel - version ": "EGG - Emulador Genérico do Gabriel - versão ",
 	// Main execution loop.
-	"Instruction execution failed: %v\n": "Falha na execução da instrução: %v\n",
+	"Instruction execution failed: %v\n":    "Falha na execução da instrução: %v\n",
+	"exceeded the limit of %v instructions": "excedeu o limite de %v instruções",
+	"exceeded the time limit of %v":         "excedeu o limite de tempo de %v",
 	// Args.
-	"Select architeture to use.":                          "Seleciona a arquitetura a ser utilizada.",
-	"Select architeture to use (shorthand).":              "Seleciona a arquitetura a ser utilizada (abrev.).",
-	"Lists currently supported architetures and quit.":    "Lista arquiteturas suportadas e sai.",
-	"Lists currently supported architetures (shorthand).": "Lista arquiteturas suportadas e sai (abrev.).",
-	"Show current version and quit.":                      "Mostra a versão atual e sai.",
-	"Show current version and quit (shorthand).":          "Mostra a versão atual e sai (abrev.).",
-	"Enter debugger upon startup.":                        "Entra no debugger após inicialização.",
-	"Enter debugger upon startup (shorthand).":            "Entra no debugger após inicialização (abrev).",
+	"Select architeture to use.":                                                    "Seleciona a arquitetura a ser utilizada.",
+	"Select architeture to use (shorthand).":                                        "Seleciona a arquitetura a ser utilizada (abrev.).",
+	"Lists currently supported architetures and quit.":                              "Lista arquiteturas suportadas e sai.",
+	"Lists currently supported architetures (shorthand).":                           "Lista arquiteturas suportadas e sai (abrev.).",
+	"Show current version and quit.":                                                "Mostra a versão atual e sai.",
+	"Show current version and quit (shorthand).":                                    "Mostra a versão atual e sai (abrev.).",
+	"Enter debugger upon startup.":                                                  "Entra no debugger após inicialização.",
+	"Enter debugger upon startup (shorthand).":                                      "Entra no debugger após inicialização (abrev).",
+	"Stop with an error after executing this many instructions (0 means no limit).": "Para com um erro após executar esse número de instruções (0 significa sem limite).",
+	"Stop with an error after running for this long, e.g., 10s (0 means no limit).": "Para com um erro após executar por esse tempo, por exemplo 10s (0 significa sem limite).",
+	"Select the call numbering: 'egg', 'rars' (RISC-V only) or 'spim' (MIPS only).": "Seleciona a numeração das chamadas: 'egg', 'rars' (somente RISC-V) ou 'spim' (somente MIPS).",
+	"Call numberings are not supported for the selected backend.":                   "Numerações de chamadas não são suportadas pelo backend selecionado.",
+	"Allow file calls to access files inside this directory.":                       "Permite que chamadas de arquivo acessem arquivos dentro desse diretório.",
+	"Read the program input from this file instead of the standard input.":          "Lê a entrada do programa desse arquivo ao invés da entrada padrão.",
+	"Write the program output to this file instead of the standard output.":         "Escreve a saída do programa nesse arquivo ao invés da saída padrão.",
+	"Record the input, time and random numbers got by the program to this file.":    "Grava a entrada, o horário e os números aleatórios obtidos pelo programa nesse arquivo.",
+	"Replay the input, time and random numbers recorded in this file.":              "Repete a entrada, o horário e os números aleatórios gravados nesse arquivo.",
+	"Address of the .data section (RISC-V, MIPS and PIÁ only).":                     "Endereço da seção .data (somente RISC-V, MIPS e PIÁ).",
+	"Initial value of the stack pointer (RISC-V, MIPS and PIÁ only).":               "Valor inicial do ponteiro da pilha (somente RISC-V, MIPS e PIÁ).",
+	"Initial value of the global pointer (RISC-V and MIPS only).":                   "Valor inicial do ponteiro global (somente RISC-V e MIPS).",
+	"Add a KEY=VALUE variable to the program environment (may be repeated).":        "Adiciona uma variável CHAVE=VALOR ao ambiente do programa (pode ser repetida).",
+	"expected KEY=VALUE": "esperado CHAVE=VALOR",
+	"Run the program again whenever the source files change.": "Executa o programa novamente sempre que os arquivos fonte mudarem.",
+	// Text base flag.
+	"Address of the .text section, or of the whole program if it has no sections (RISC-V, MIPS and PIÁ only).": "Endereço da seção .text, ou do programa inteiro se ele não possui seções (somente RISC-V, MIPS e PIÁ).",
+	// Entry flag.
+	"Label where the execution starts, if it's defined (RISC-V, MIPS and PIÁ only).": "Label onde a execução começa, caso esteja definido (somente RISC-V, MIPS e PIÁ).",
+	// Watching files.
+	"Source files changed, running again.": "Arquivos fonte modificados, executando novamente.",
+	// Interrupts (also used by the debugger).
+	"Interrupted at address 0x%x\n":   "Interrompido no endereço 0x%x\n",
+	"Program exited with status %v\n": "Programa terminou com status %v\n",
 	// main().
-	"Unknown architeture: %v\n":                            "Arquitetura desconhecida: %v\n",
-	"No Assembly file supplied.":                           "Nenhum arquivo Assembly providenciado.",
-	"Could not read supplied file %v\n":                    "Erro lendo o arquivo %v providenciado",
-	"Error assembling file %v: %v\n":                       "Erro montando o arquivo %v: %v\n",
-	"Error loading assembled program: %v\n":                "Erro carregando o programa montado: %v\n",
-	"Debugging is not supported for the selected backend.": "Debugging não é suportado pelo backend selecionado.",
+	"Unknown architeture: %v\n":                                     "Arquitetura desconhecida: %v\n",
+	"No Assembly file supplied.":                                    "Nenhum arquivo Assembly providenciado.",
+	"Could not read supplied file %v\n":                             "Erro lendo o arquivo %v providenciado",
+	"Error assembling file %v: %v\n":                                "Erro montando o arquivo %v: %v\n",
+	"Error loading assembled program: %v\n":                         "Erro carregando o programa montado: %v\n",
+	"Debugging is not supported for the selected backend.":          "Debugging não é suportado pelo backend selecionado.",
+	"Memory layouts are not supported for the selected backend.":    "Layouts de memória não são suportados pelo backend selecionado.",
+	"Program arguments are not supported for the selected backend.": "Argumentos de programa não são suportados pelo backend selecionado.",
+
+	//
+	// calls.go.
+	//
+	"error reading input: %v":    "erro lendo a entrada: %v",
+	"cannot parse %v as integer": "impossível converter %v para inteiro",
+	"file calls are disabled":    "chamadas de arquivos estão desabilitadas",
+	"unknown open flags: %v":     "flags de open desconhecidas: %v",
+	"bad file descriptor: %v":    "descritor de arquivo inválido: %v",
+	"unknown whence: %v":         "whence desconhecido: %v",
+	"File call failed: %v\n":     "Chamada de arquivo falhou: %v\n",
+
+	//
+	// record.go.
+	//
+	"malformed replay file: %v": "arquivo de repetição malformado: %v",
+	"recorded call %v but the program performed call %v, not replaying anymore": "chamada %v gravada mas o programa realizou a chamada %v, não repetindo mais",
 
 	//
 	// debugger.go.
@@ -115,26 +231,93 @@ next
 	Abreviação: n
 continue
 	Continua a execução até uma chamada BREAK ou um ponto de parada
-	(breakpoint).
-break [expr]
+	(breakpoint). Ctrl-C interrompe a execução e retorna ao prompt.
+back [quantidade]
+	Desfaz a última instrução executada (ou as últimas instruções), incluindo
+	a memória escrita por chamadas READ. Chamadas desfeitas recebem a mesma
+	entrada, horário e números aleatórios quando executadas novamente, e SBRK
+	também é desfeita, mas arquivos abertos, fechados, lidos ou escritos não.
+	Abreviação: bk
+reverse-continue
+	Desfaz instruções até chegar em um ponto de parada (breakpoint) ou até não
+	haver mais nada para desfazer.
+	Abreviação: rc
+break [expr] [if <condição>]
 	Com um argumento, cria um novo ponto de parada (breakpoint). Sem o
-	argumento, mostra todos os pontos de parada. Aceita números, etiquetas Assembly e <arquivo>:<linha>.
+	argumento, mostra todos os pontos de parada com seus números e
+	contadores. Aceita números, etiquetas Assembly e <arquivo>:<linha>. Com
+	uma condição, o ponto de parada só para quando a condição é verdadeira.
+	Adicionar uma condição a um ponto existente muda sua condição.
 	Abreviação: b
+ignore <número> <quantidade>
+	Ignora as próximas vezes que um ponto de parada é atingido.
+	Abreviação: i
+enable <número>...
+	Habilita pontos de parada (breakpoints).
+	Abreviação: en
+disable <número>...
+	Desabilita pontos de parada (breakpoints), que são mantidos mas não param
+	a execução.
+	Abreviação: dis
+delete [número]...
+	Deleta pontos de parada (breakpoints) pelos seus números. Sem argumentos,
+	deleta todos os pontos de parada.
+	Abreviação: del
 remove <expr>
 	Remove um ponto de parada (breakpoint). Aceita números e etiquetas Assembly.
 	Abreviação: r
+watch [expr]
+	Com um argumento, cria um novo ponto de observação (watchpoint): continue
+	para quando o registrador ou a memória é escrito. Sem o argumento, mostra
+	todos os pontos de observação. Aceita <registrador> e <expr>@<tamanho>.
+	Abreviação: w
+rwatch <expr>@<tamanho>
+	Igual à watch, porém para quando a memória é lida.
+	Abreviação: rw
+unwatch <expr>
+	Remove os pontos de observação (watchpoints) de um registrador ou memória.
+	Abreviação: uw
 dump <endereço>@<tamanho> <arquivo>
 	Salva conteúdo da memória em um arquivo.
 	Abreviação: d
 rewind
 	Recarrega a máquina, isso é, pede para que ela retorne ao estado original.
+	A entrada, o horário e os números aleatórios obtidos pelo programa são
+	repetidos.
 	Abreviação: rew
 reload
-	Recarrega os arquivos Assembly e então recarrega a máquina.
+	Recarrega os arquivos Assembly e então recarrega a máquina. Pontos de
+	parada (breakpoints) criados a partir de etiquetas e <arquivo>:<linha>
+	são movidos para seguir suas instruções.
 	Abreviação: rel
+watch-files [on|off]
+	Começa ou para de observar os arquivos Assembly (incluindo os incluídos
+	com .include). Enquanto observando, o programa é recarregado como com
+	reload toda vez que algum deles é salvo. Sem o argumento, mostra se os
+	arquivos estão sendo observados.
+	Abreviação: wf
+input [arquivo]
+	Lê a entrada do programa de um arquivo, que é lido do início novamente
+	no rewind e no reload. Sem o argumento, lê do console.
+	Abreviação: in
+output [arquivo]
+	Escreve a saída do programa em um arquivo. Sem o argumento, escreve no
+	console.
+	Abreviação: out
+tty [dispositivo]
+	Igual a input e output com o mesmo dispositivo, por exemplo, outro
+	terminal (rode 'tty' nele para descobrir seu dispositivo). Arquivos comuns
+	são rejeitados.
 set <expr>[@tamanho] <conteúdo>
 	Muda o conteúdo de registradores ou da memória.
 	Abreviação: s
+checkpoint [nome]
+	Com um argumento, salva o estado atual da máquina com esse nome. Sem o
+	argumento, mostra todos os checkpoints. Checkpoints são perdidos no reload.
+	Abreviação: cp
+restore <nome>
+	Retorna a máquina ao estado salvo em um checkpoint.
+	Abreviação: res
 exit
 	Termina a sessão de debugging.
 	Abreviação: e
@@ -149,6 +332,13 @@ O comando print, de maneira geral, segue as seguintes regras:
   imprime o conteúdo da memória endereçada pelo conteúdo do registrador.
 O comando set funciona da mesma maneira.
 
+Condições de pontos de parada são escritas como <operando> <operador>
+<operando>, com os operadores ==, !=, <, <=, > e >=. Operandos podem ser
+registradores, números ou uma palavra da memória, escrita como
+[<registrador>+<deslocamento>], [<registrador>-<deslocamento>] ou [<endereço>].
+Valores são comparados como números com sinal, por exemplo, 'break loop if t0
+== 10' ou 'break 0x40 if [sp+4] > 3'.
+
 O comando dump também aceita registradores, porém sempre os utiliza como
 endereços.
 
@@ -160,31 +350,35 @@ No comando print, <addr>#tamanho significa "tantas instruções após addr".
 #<tamanho> é uma abreviação para usar o endereço da instrução atual.
 `,
 	// Debugger functions.
-	"cannot parse %v as number: %v":                                        "impossível converter %v para número",
-	"cannot parse %v as number: %v\n":                                      "impossível converter %v para número\n",
-	"length not supplied":                                                  "tamanho não providenciado",
-	"cannot parse %v as address":                                           "impossível converter %v para endereço",
-	"%v is not a number":                                                   "%v não é um número",
-	"%v is not an unsigned number":                                         "%v não é um número sem sinal",
-	"no instruction at address 0x%x":                                       "nenhuma instrução no endereço 0x%x",
-	"%v is not a register or address":                                      "%v não é um registrador ou endereço",
-	"cannot get memory content: %v":                                        "não foi possível ler o conteúdo da memória: %v",
-	"cannot get register content: %v":                                      "não foi possível ler o conteúdo do registrador: %v",
-	"print expects one argument: <expr>[@<length>] or [<addr>]#[<length>]": "print necessita de um argumento: <expr>[@<tamanho>] ou [<addr>]#[<tamanho>]",
-	"READ call for address 0x%x with %d bytes:\n":                          "Chamada READ para o endereço 0x%x com %d bytes:\n",
-	"Error reading stdin: %v\n":                                            "Erro lendo input padrão: %v\n",
-	"Register %v: changed from 0x%02x to 0x%02x\n":                         "Registrador %v: mudou de 0x%02x para 0x%02x\n",
-	"Register %v: changed from 0x%04x to 0x%04x\n":                         "Registrador %v: mudou de 0x%04x para 0x%04x\n",
-	"Register %v: changed from 0x%08x to 0x%08x\n":                         "Registrador %v: mudou de 0x%08x para 0x%08x\n",
-	"Register %v: changed from 0x%016x to 0x%016x\n":                       "Registrador %v: mudou de 0x%016x para 0x%016x\n",
-	"BREAK call while stepping at address 0x%x\n":                          "Chamada BREAK enquanto executando o endereço 0x%x\n",
-	"Stopped at BREAK call at address 0x%x\n":                              "Parado na chamada BREAK no endereço 0x%x\n",
-	"Breakpoints:":                       "Pontos de parada (breakpoints):",
-	"%v is not a number.\n":              "%v não é um número.\n",
-	"Breakpoint already exists":          "Ponto de parada (breakpoint) já existe.",
-	"Cannot parse %v as address.\n":      "Impossível converter %v para endereço.\n",
-	"cannot parse %v as a dump argument": "impossível converter %v para um argumento de dump",
-	"error getting memory chunk: %v":     "erro lendo região da memória: %v",
+	"cannot parse %v as number: %v":                                               "impossível converter %v para número",
+	"cannot parse %v as number: %v\n":                                             "impossível converter %v para número\n",
+	"length not supplied":                                                         "tamanho não providenciado",
+	"cannot parse %v as address":                                                  "impossível converter %v para endereço",
+	"%v is not a number":                                                          "%v não é um número",
+	"%v is not an unsigned number":                                                "%v não é um número sem sinal",
+	"no instruction at address 0x%x":                                              "nenhuma instrução no endereço 0x%x",
+	"%v is not a register or address":                                             "%v não é um registrador ou endereço",
+	"cannot get memory content: %v":                                               "não foi possível ler o conteúdo da memória: %v",
+	"cannot get register content: %v":                                             "não foi possível ler o conteúdo do registrador: %v",
+	"print expects one argument: <expr>[@<length>] or [<addr>]#[<length>]":        "print necessita de um argumento: <expr>[@<tamanho>] ou [<addr>]#[<tamanho>]",
+	"READ call for address 0x%x with %d bytes:\n":                                 "Chamada READ para o endereço 0x%x com %d bytes:\n",
+	"READ_INT call:":                                                              "Chamada READ_INT:",
+	"READ_CHAR call:":                                                             "Chamada READ_CHAR:",
+	"READ_STRING call for address 0x%x with %d bytes:\n":                          "Chamada READ_STRING para o endereço 0x%x com %d bytes:\n",
+	"Replaying recorded input.":                                                   "Repetindo a entrada gravada.",
+	"Error performing call: %v\n":                                                 "Erro realizando chamada: %v\n",
+	"Register %v: changed from 0x%02x to 0x%02x\n":                                "Registrador %v: mudou de 0x%02x para 0x%02x\n",
+	"Register %v: changed from 0x%04x to 0x%04x\n":                                "Registrador %v: mudou de 0x%04x para 0x%04x\n",
+	"Register %v: changed from 0x%08x to 0x%08x\n":                                "Registrador %v: mudou de 0x%08x para 0x%08x\n",
+	"Register %v: changed from 0x%016x to 0x%016x\n":                              "Registrador %v: mudou de 0x%016x para 0x%016x\n",
+	"BREAK call while stepping at address 0x%x\n":                                 "Chamada BREAK enquanto executando o endereço 0x%x\n",
+	"Stopped at BREAK call at address 0x%x\n":                                     "Parado na chamada BREAK no endereço 0x%x\n",
+	"Breakpoints:":                                                                "Pontos de parada (breakpoints):",
+	"%v is not a number.\n":                                                       "%v não é um número.\n",
+	"Breakpoint already exists":                                                   "Ponto de parada (breakpoint) já existe.",
+	"Cannot parse %v as address.\n":                                               "Impossível converter %v para endereço.\n",
+	"cannot parse %v as a dump argument":                                          "impossível converter %v para um argumento de dump",
+	"error getting memory chunk: %v":                                              "erro lendo região da memória: %v",
 	"dump expects two arguments: (<expr>@<length> or [<addr>]#[<length>]) <file>": "dump necessita de dois argumentos: (<expr>@<tamanho> ou [<addr>]#[<tamanho>]) <arquivo>",
 	"Cannot get content to dump: %v\n":                                            "Não foi possível ler o conteúdo para o dump: %v\n",
 	"Cannot open %s for write: %v\n":                                              "Não foi possível abrir %s para escrita: %v\n",
@@ -211,6 +405,75 @@ No comando print, <addr>#tamanho significa "tantas instruções após addr".
 	"Keeping old program and program state.": "Mantendo o programa e estado anteriores",
 	"Error loading new assembled code:":      "Erro carregando o novo código montado:",
 	"Rebuild Assembly.":                      "Assembly remontado.",
+	"Keeping old program.":                   "Mantendo o programa anterior.",
+	// Checkpoints.
+	"Checkpoints:": "Checkpoints:",
+	"Checkpoints are not supported for the selected backend.": "Checkpoints não são suportados pelo backend selecionado.",
+	"New checkpoint %v at address 0x%x\n":                     "Novo checkpoint %v no endereço 0x%x\n",
+	"restore expects a checkpoint name: restore <name>":       "restore necessita do nome de um checkpoint: restore <nome>",
+	"No checkpoint %v\n":                                      "Nenhum checkpoint %v\n",
+	"Error while restoring checkpoint: %v\n":                  "Erro enquanto restaurando o checkpoint: %v\n",
+	"Restored checkpoint %v\n":                                "Checkpoint %v restaurado\n",
+	// Reverse execution.
+	"Reverse execution is not supported for the selected backend.": "Execução reversa não é suportada pelo backend selecionado.",
+	"No more instructions to undo.":                                "Não há mais instruções para desfazer.",
+	// Conditional breakpoints.
+	"missing ] in %v":                                  "] faltando em %v",
+	"%v is not a register or number":                   "%v não é um registrador ou número",
+	"cannot parse %v as condition":                     "impossível converter %v para condição",
+	"Cannot evaluate condition of breakpoint %v: %v\n": "Impossível avaliar a condição do ponto de parada (breakpoint) %v: %v\n",
+	" (hit %v times)":                                  " (atingido %v vezes)",
+	" (ignoring next %v hits)":                         " (ignorando as próximas %v vezes)",
+	"break expects a condition after the breakpoint: break <expr> if <condition>": "break necessita de uma condição após o ponto de parada: break <expr> if <condição>",
+	"Changed condition of breakpoint %v\n":                                        "Condição do ponto de parada (breakpoint) %v modificada\n",
+	"ignore expects a breakpoint number and a count: ignore <number> <count>":     "ignore necessita do número de um ponto de parada e uma quantidade: ignore <número> <quantidade>",
+	"Will ignore next %v hits of breakpoint %v\n":                                 "As próximas %v vezes do ponto de parada (breakpoint) %v serão ignoradas\n",
+	// Breakpoint numbers.
+	" (disabled)":              " (desabilitado)",
+	"no breakpoint number %v":  "nenhum ponto de parada (breakpoint) número %v",
+	"Deleted all breakpoints.": "Todos os pontos de parada (breakpoints) foram deletados.",
+	"enable expects breakpoint numbers: enable <number>...":   "enable necessita de números de pontos de parada: enable <número>...",
+	"disable expects breakpoint numbers: disable <number>...": "disable necessita de números de pontos de parada: disable <número>...",
+	"Deleted breakpoint %v: %v\n":                             "Ponto de parada (breakpoint) %v deletado: %v\n",
+	"Breakpoint %v does not map to an instruction anymore\n":  "Ponto de parada (breakpoint) %v não corresponde mais a uma instrução\n",
+	"Moved breakpoint %v to 0x%x\n":                           "Ponto de parada (breakpoint) %v movido para 0x%x\n",
+	// Breakpoints merged on reload.
+	"Deleted breakpoint %v: same address as breakpoint #%v\n": "Ponto de parada (breakpoint) %v deletado: mesmo endereço do ponto de parada #%v\n",
+	// Watchpoints.
+	"Watchpoint %v read by:\n":             "Ponto de observação (watchpoint) %v lido por:\n",
+	"Watchpoint %v written by:\n":          "Ponto de observação (watchpoint) %v escrito por:\n",
+	"Old value: 0x%x\n":                    "Valor anterior: 0x%x\n",
+	"New value: 0x%x\n":                    "Novo valor: 0x%x\n",
+	"Value:":                               "Valor:",
+	"Old value:":                           "Valor anterior:",
+	"New value:":                           "Novo valor:",
+	"Watchpoints:":                         "Pontos de observação (watchpoints):",
+	"%v (read)\n":                          "%v (leitura)\n",
+	"only memory can be watched for reads": "somente a memória pode ser observada para leituras",
+	"cannot watch 0 bytes":                 "impossível observar 0 bytes",
+	"Watchpoints are not supported for the selected backend.": "Pontos de observação (watchpoints) não são suportados pelo backend selecionado.",
+	"Watchpoint already exists":                               "Ponto de observação (watchpoint) já existe.",
+	"New watchpoint %v\n":                                     "Novo ponto de observação (watchpoint) %v\n",
+	"unwatch expects a watchpoint to remove: unwatch <expr>":  "unwatch necessita de um ponto (watchpoint) para remover: unwatch <expr>",
+	"No watchpoint %v\n":                                      "Nenhum ponto de observação (watchpoint) %v\n",
+	// Program input and output.
+	"Error redirecting input: %v\n":   "Erro redirecionando a entrada: %v\n",
+	"Error redirecting output: %v\n":  "Erro redirecionando a saída: %v\n",
+	"Program input from the console.": "Entrada do programa a partir do console.",
+	"Program input from %v\n":         "Entrada do programa a partir de %v\n",
+	"Program output to the console.":  "Saída do programa para o console.",
+	"Program output to %v\n":          "Saída do programa para %v\n",
+	// TTY.
+	"Error redirecting input and output: %v\n": "Erro redirecionando a entrada e a saída: %v\n",
+	"%v is not a device":                       "%v não é um dispositivo",
+	// Watching files.
+	"Not watching source files.":                                            "Não observando os arquivos fonte.",
+	"Watching source files.":                                                "Observando os arquivos fonte.",
+	"Already watching source files.":                                        "Os arquivos fonte já estão sendo observados.",
+	"Stopped watching source files.":                                        "Parou de observar os arquivos fonte.",
+	"Source files changed.":                                                 "Arquivos fonte modificados.",
+	"Use watch-files on or watch-files off.":                                "Use watch-files on ou watch-files off.",
+	"Watching source files. The program will be reloaded when they change.": "Observando os arquivos fonte. O programa será recarregado quando eles mudarem.",
 
 	//
 	// assembler.go and tokenizer.go
@@ -227,6 +490,34 @@ No comando print, <addr>#tamanho significa "tantas instruções após addr".
 	"%v:%v: Expected file name to include":                      "%v:%v: Nome de arquivo para inclusão esperado.",
 	"%v:%v: Unknown directive %v":                               "%v:%v: Diretiva desconhecida %v",
 	"%v:%v: Expected literal content":                           "%v:%v: Conteúdo literal esperado",
+	"%v:%v: Error on constant translation: %v":                  "%v:%v: Erro na tradução de constante: %v",
+	"unexpected %q in expression %v":                            "%q inesperado na expressão %v",
+	"division by zero in expression %v":                         "divisão por zero na expressão %v",
+	"unexpected end of expression %v":                           "fim inesperado da expressão %v",
+	"missing ) in expression %v":                                "falta ) na expressão %v",
+	"missing ( in expression %v":                                "falta ( na expressão %v",
+	"unknown function %%%v in expression %v":                    "função %%%v desconhecida na expressão %v",
+	"unknown name %v in expression %v":                          "nome %v desconhecido na expressão %v",
+	"cannot parse %v as a number in expression %v":              "impossível converter %v para número na expressão %v",
+	"cannot parse %v as a number":                               "impossível converter %v para número",
+	"malformed character literal in expression %v":              "literal de caractere malformado na expressão %v",
+	"%v:%v: Unterminated macro %v":                              "%v:%v: Macro %v não terminado",
+	"%v:%v: Expected a macro name":                              "%v:%v: Nome de macro esperado",
+	"%v:%v: Expected a parameter name":                          "%v:%v: Nome de parâmetro esperado",
+	"%v:%v: Macros cannot be defined inside other macros":       "%v:%v: Macros não podem ser definidos dentro de outros macros",
+	"%v:%v: Macro %v expects %v arguments, got %v":              "%v:%v: Macro %v espera %v argumentos, recebeu %v",
+	"%v:%v: Macro %v expanded too deeply (is it recursive?)":    "%v:%v: Macro %v expandido profundamente demais (ele é recursivo?)",
+	"%v:%v: .endm without .macro":                               "%v:%v: .endm sem .macro",
+	"%v:%v: Expected a name and a value after %v directive":     "%v:%v: Nome e valor esperados após a diretiva %v",
+	"%v:%v: Unexpected argument after %v directive":             "%v:%v: Argumento inesperado após a diretiva %v",
+	"%v:%v: .bss can only reserve space":                        "%v:%v: .bss só pode reservar espaço",
+	"%v:%v: Expected a value after %v directive":                "%v:%v: Valor esperado após a diretiva %v",
+	"%v:%v: Invalid alignment %v":                               "%v:%v: Alinhamento inválido %v",
+	"%v:%v: .org cannot move backwards from 0x%x to 0x%x":       "%v:%v: .org não pode voltar de 0x%x para 0x%x",
+	"%v:%v: .%s %s goes outside of the address space":           "%v:%v: .%s %s sai do espaço de endereçamento",
+	// Sections.
+	"%v:%v: Sections are not supported by the selected architecture":     "%v:%v: Seções não são suportadas pela arquitetura selecionada",
+	"the text section (0x%x-0x%x) overlaps the data section (0x%x-0x%x)": "a seção de texto (0x%x-0x%x) sobrepõe a seção de dados (0x%x-0x%x)",
 
 	//
 	// riscv.go and others.
@@ -245,6 +536,10 @@ No comando print, <addr>#tamanho significa "tantas instruções após addr".
 	"error executing sra: negative shift amount":                           "erro executando sra: tamanho de shift negativo.",
 	// Version 3 updates.
 	"%v:%v: Error assembling: %v": "%v:%v: Erro montando: %v",
+	// Call numberings.
+	"unsupported call numbering: %v": "numeração de chamadas não suportada: %v",
+	"unsupported RARS call: %v":      "chamada do RARS não suportada: %v",
+	"unsupported SPIM call: %v":      "chamada do SPIM não suportada: %v",
 
 	//
 	// mips.go specific.
@@ -267,4 +562,21 @@ No comando print, <addr>#tamanho significa "tantas instruções após addr".
 	"could not load 2 bytes from address at PC: %x": "não foi possível carregar 2 bytes do endereço do PC: %x",
 	"misaligned instruction":                        "instrução desalinhada",
 	"reserved instruction":                          "instrução reservada",
+
+	//
+	// machine.go.
+	//
+	"snapshot was created by %v, not %v": "snapshot foi criado por %v, não %v",
+	"malformed snapshot":                 "snapshot malformado",
+
+	//
+	// args.go.
+	//
+	"arguments do not fit in memory": "os argumentos não cabem na memória",
+
+	//
+	// layout.go.
+	//
+	"memory layout addresses must fit in 32 bits": "os endereços do layout de memória devem caber em 32 bits",
+	"text base 0x%x is not aligned to 4 bytes":    "a base do texto 0x%x não está alinhada a 4 bytes",
 }
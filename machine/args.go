@@ -0,0 +1,73 @@
+package machine
+
+import (
+	"encoding/binary"
+	"errors"
+	"slices"
+)
+
+// Interface implemented by machines that can pass command line arguments and
+// environment variables to programs.
+type ArgumentsSetter interface {
+	// Writes the arguments and environment (as KEY=VALUE strings) into memory
+	// and sets the registers used by the architecture to pass them. Must be
+	// called after LoadProgram.
+	SetArguments(args []string, env []string) error
+}
+
+// Writes the arguments and environment of a program below top, as Linux does:
+// the stack pointer points to argc, followed by the argv pointers, a NULL, the
+// envp pointers and a NULL. The NUL-terminated strings are above them. Words
+// have wordSize (4 or 8) bytes and the stack pointer is aligned to 16 bytes.
+// Returns the stack pointer and the addresses of argv and envp.
+func LayoutArguments(m Machine, top uint64, wordSize uint64, order binary.ByteOrder, args []string, env []string) (sp uint64, argv uint64, envp uint64, err error) {
+	all := slices.Concat(args, env)
+	size := uint64(0)
+	for _, s := range all {
+		size += uint64(len(s)) + 1
+	}
+	words := 1 + uint64(len(args)) + 1 + uint64(len(env)) + 1
+	if size+words*wordSize+16 > top {
+		return 0, 0, 0, errors.New(InterCtx.Get("arguments do not fit in memory"))
+	}
+
+	addr := top - size
+	sp = (addr - words*wordSize) &^ 15
+
+	// Strings.
+	pointers := make([]uint64, 0, words)
+	pointers = append(pointers, uint64(len(args)))
+	for i, s := range all {
+		if i == len(args) {
+			pointers = append(pointers, 0)
+		}
+		pointers = append(pointers, addr)
+		err = m.SetMemoryChunk(addr, append([]uint8(s), 0))
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		addr += uint64(len(s)) + 1
+	}
+	if len(env) == 0 {
+		pointers = append(pointers, 0)
+	}
+	pointers = append(pointers, 0)
+
+	// argc and pointers.
+	buf := make([]uint8, wordSize)
+	for i, p := range pointers {
+		if wordSize == 4 {
+			order.PutUint32(buf, uint32(p))
+		} else {
+			order.PutUint64(buf, p)
+		}
+		err = m.SetMemoryChunk(sp+uint64(i)*wordSize, buf)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+	}
+
+	argv = sp + wordSize
+	envp = argv + (uint64(len(args))+1)*wordSize
+	return sp, argv, envp, nil
+}
@@ -0,0 +1,140 @@
+package machine
+
+// A single write recorded by a Journal.
+type journalEntry struct {
+	register bool
+	// Register number or memory address.
+	addr uint64
+	// Value before the write.
+	old uint64
+}
+
+// A step is everything written between a Begin and an End.
+type journalStep struct {
+	pc    uint64
+	first int
+	// Undo what the step changed outside of the machine.
+	undo []func()
+}
+
+// Journal is an Observer that records the old value of every register and
+// memory write performed by a machine, grouped in steps (usually one
+// instruction plus any memory written by it's call), so the steps may be undone
+// later.
+//
+// All methods may be called on a nil *Journal, in which case they do nothing.
+type Journal struct {
+	entries   []journalEntry
+	steps     []journalStep
+	limit     int
+	recording bool
+}
+
+// Creates a journal which remembers at most limit steps. When the limit is
+// reached, the oldest steps are forgotten.
+func NewJournal(limit int) *Journal {
+	return &Journal{limit: max(limit, 1)}
+}
+
+// Starts recording a new step. pc is the instruction address the machine will
+// return to when the step is undone.
+func (j *Journal) Begin(pc uint64) {
+	if j == nil {
+		return
+	}
+
+	if len(j.steps) >= j.limit {
+		// Forget the oldest half at once so this only happens once in a
+		// while.
+		drop := j.steps[len(j.steps)/2]
+		j.entries = append(j.entries[:0], j.entries[drop.first:]...)
+		j.steps = append(j.steps[:0], j.steps[len(j.steps)/2:]...)
+		for i := range j.steps {
+			j.steps[i].first -= drop.first
+		}
+	}
+
+	j.steps = append(j.steps, journalStep{pc: pc, first: len(j.entries)})
+	j.recording = true
+}
+
+// Stops recording the current step. Writes performed after this (e.g., by the
+// user changing registers in the debugger) are not recorded.
+func (j *Journal) End() {
+	if j == nil {
+		return
+	}
+	j.recording = false
+}
+
+func (j *Journal) OnMemoryRead(addr uint64, size uint64) {}
+
+func (j *Journal) OnRegisterWrite(reg uint64, old uint64, new uint64) {
+	if j == nil || !j.recording {
+		return
+	}
+	j.entries = append(j.entries, journalEntry{register: true, addr: reg, old: old})
+}
+
+func (j *Journal) OnMemoryWrite(addr uint64, old uint8, new uint8) {
+	if j == nil || !j.recording {
+		return
+	}
+	j.entries = append(j.entries, journalEntry{addr: addr, old: uint64(old)})
+}
+
+// Number of steps that can be undone.
+func (j *Journal) Steps() int {
+	if j == nil {
+		return 0
+	}
+	return len(j.steps)
+}
+
+// Adds a function that undoes something changed outside of the machine in the
+// current step, e.g., by a call. Undo calls it after writing the old values
+// back.
+func (j *Journal) OnUndo(undo func()) {
+	if j == nil || !j.recording {
+		return
+	}
+	step := &j.steps[len(j.steps)-1]
+	step.undo = append(step.undo, undo)
+}
+
+// Undoes the last step recorded, writing the old values back into m. Returns
+// false if there's no step to undo.
+func (j *Journal) Undo(m Machine) bool {
+	if j == nil || len(j.steps) == 0 {
+		return false
+	}
+
+	j.recording = false
+	step := j.steps[len(j.steps)-1]
+	for i := len(j.entries) - 1; i >= step.first; i-- {
+		e := j.entries[i]
+		if e.register {
+			_ = m.SetRegister(e.addr, e.old)
+		} else {
+			_ = m.SetMemory(e.addr, uint8(e.old))
+		}
+	}
+	_ = m.SetCurrentInstructionAddress(step.pc)
+	for i := len(step.undo) - 1; i >= 0; i-- {
+		step.undo[i]()
+	}
+
+	j.entries = j.entries[:step.first]
+	j.steps = j.steps[:len(j.steps)-1]
+	return true
+}
+
+// Forgets every step recorded.
+func (j *Journal) Clear() {
+	if j == nil {
+		return
+	}
+	j.entries = j.entries[:0]
+	j.steps = j.steps[:0]
+	j.recording = false
+}
@@ -0,0 +1,76 @@
+package machine
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Conventional section bases and initial stack and global pointers of 32 bits
+// machines, the same used by RARS and MARS.
+const (
+	TEXT_BASE_32      = 0x00400000
+	DATA_BASE_32      = 0x10010000
+	STACK_TOP_32      = 0x7fffeffc
+	GLOBAL_POINTER_32 = 0x10008000
+)
+
+// Label where the execution starts by default, as in the GNU linker.
+const ENTRY_LABEL = "_start"
+
+// Where a program is placed in memory and the initial values of the registers
+// pointing to memory.
+type MemoryLayout struct {
+	// Address programs without sections are assembled and loaded at.
+	FlatBase uint64
+	// Addresses of the .text and .data sections. .bss follows .data.
+	TextBase uint64
+	DataBase uint64
+	// Initial value of the stack pointer.
+	StackTop uint64
+	// Initial value of the global pointer.
+	GlobalPointer uint64
+	// Label where the execution starts, if it's defined. Otherwise, it
+	// starts at the start of the text.
+	Entry string
+}
+
+// Interface implemented by machines with a configurable memory layout. The
+// layout is used by Assemble and LoadProgram, so it should be set before
+// assembling.
+type LayoutSetter interface {
+	// Returns the current layout, which starts as the backend default.
+	Layout() MemoryLayout
+	SetLayout(MemoryLayout) error
+	// Returns the first address after the assembled program, where the
+	// heap may start.
+	ProgramEnd() uint64
+}
+
+// Default layout of 32 bits machines, with programs without sections at
+// address 0.
+var DefaultLayout32 = MemoryLayout{
+	FlatBase:      0,
+	TextBase:      TEXT_BASE_32,
+	DataBase:      DATA_BASE_32,
+	StackTop:      STACK_TOP_32,
+	GlobalPointer: GLOBAL_POINTER_32,
+	Entry:         ENTRY_LABEL,
+}
+
+// Checks that every address of a layout fits in 32 bits and that the program and
+// it's sections are aligned to 4 bytes. Used by 32 bits backends before setting
+// a layout.
+func CheckLayout32(l MemoryLayout) error {
+	for _, addr := range []uint64{l.FlatBase, l.TextBase, l.DataBase, l.StackTop, l.GlobalPointer} {
+		if addr > math.MaxUint32 {
+			return errors.New(InterCtx.Get("memory layout addresses must fit in 32 bits"))
+		}
+	}
+	for _, base := range []uint64{l.FlatBase, l.TextBase, l.DataBase} {
+		if base%4 != 0 {
+			return fmt.Errorf(InterCtx.Get("base address 0x%x is not aligned to 4 bytes"), base)
+		}
+	}
+	return nil
+}
@@ -2,6 +2,9 @@
 package machine
 
 import (
+	"errors"
+	"fmt"
+
 	"github.com/gboncoffee/egg/assembler"
 	"github.com/gboncoffee/intergo"
 )
@@ -22,6 +25,12 @@ type Machine interface {
 	// anything the machine may need. After this, the machine should be
 	// ready to receive sequences of NextInstruction().
 	LoadProgram([]uint8) error
+	// Returns the machine to it's power-on state: registers, memory, the
+	// instruction pointer and any backend-specific state are cleared. It
+	// should be called before LoadProgram whenever the program must start
+	// from scratch, as LoadProgram only overwrites the memory used by the
+	// program itself.
+	Reset()
 	// Executes the next instruction. The execution should be handled in a
 	// way that the instruction is executed and only them the instruction
 	// pointer is incremented, thus the pointer always points to the
@@ -59,10 +68,69 @@ type Machine interface {
 	// Self-explanatory. Usually just a "return m.pc" or something like
 	// that.
 	GetCurrentInstructionAddress() uint64
+	// Same as GetCurrentInstructionAddress but for setting. Should return an
+	// error if the address is not addressable.
+	SetCurrentInstructionAddress(uint64) error
 	// Self-explanatory.
 	ArchitectureInfo() ArchitectureInfo
 }
 
+// A chunk of memory saved in a Snapshot.
+type MemoryChunk struct {
+	Address uint64
+	Content []uint8
+}
+
+// A copy of the whole state of a machine. It only holds plain values, so it
+// may be serialized with encoding/gob, encoding/json and friends.
+type Snapshot struct {
+	// Name of the architecture that created the snapshot, as in
+	// ArchitectureInfo.
+	Architecture string
+	Registers    []uint64
+	PC           uint64
+	// Backend-specific state that is not visible as a register (e.g., HI
+	// and LO in MIPS).
+	Extra []uint64
+	// Only the memory touched by the program is saved. Everything else is
+	// zero.
+	Memory []MemoryChunk
+}
+
+// Interface implemented by machines that can save and restore their state.
+type Snapshotter interface {
+	// Copies the current state of the machine.
+	Snapshot() Snapshot
+	// Returns the machine to the state saved in the snapshot. Fails if the
+	// snapshot was created by another architecture.
+	Restore(Snapshot) error
+}
+
+// Checks if a snapshot was created by the architecture with the given name, and
+// has the expected number of registers and extra state values. The registers,
+// the PC and the memory cannot go past max, the last address of the machine.
+// Used by backends before restoring, so a bad snapshot never leaves the machine
+// half restored.
+func CheckSnapshot(s Snapshot, architecture string, registers int, extra int, max uint64) error {
+	if s.Architecture != architecture {
+		return fmt.Errorf(InterCtx.Get("snapshot was created by %v, not %v"), s.Architecture, architecture)
+	}
+	if len(s.Registers) != registers || len(s.Extra) != extra || s.PC > max {
+		return errors.New(InterCtx.Get("malformed snapshot"))
+	}
+	for _, r := range s.Registers {
+		if r > max {
+			return errors.New(InterCtx.Get("malformed snapshot"))
+		}
+	}
+	for _, chunk := range s.Memory {
+		if len(chunk.Content) > 0 && (chunk.Address > max || uint64(len(chunk.Content)-1) > max-chunk.Address) {
+			return errors.New(InterCtx.Get("malformed snapshot"))
+		}
+	}
+	return nil
+}
+
 // Syscalls numbers. ISAs with specific calls for BREAK should send a BREAK on them.
 //
 // BREAK - 1 - Transfer control to debugger or stop machine.
@@ -75,17 +143,141 @@ type Machine interface {
 // WRITE - 3 - Write output.
 // - Arg1: Buffer address (will be put into GetMemoryChunk()).
 // - Arg2: Size in bytes of output.
+//
+// EXIT - 4 - Stop machine with an exit status.
+// - Arg1: Exit status. Only the lowest 8 bits are used, as in POSIX.
+//
+// PRINT_INT - 5 - Write a signed integer in decimal.
+// - Arg1: Integer.
+//
+// PRINT_UINT - 6 - Write an unsigned integer in decimal.
+// - Arg1: Integer.
+//
+// READ_INT - 7 - Read a line of input with an integer (in any base accepted by
+// the assembler).
+// - Returns the integer.
+//
+// PRINT_STRING - 8 - Write a NUL-terminated string.
+// - Arg1: String address.
+//
+// SBRK - 9 - Grow (or shrink) the heap, which starts after the program.
+// - Arg1: Signed number of bytes to grow.
+// - Returns the address of the old end of the heap, i.e., the new memory.
+//
+// TIME - 10 - Get the current time.
+// - Returns the milliseconds since the Unix epoch (truncated to the word width).
+//
+// RANDOM - 11 - Get a random number.
+// - Returns a random word.
+//
+// PRINT_CHAR - 12 - Write a single byte.
+// - Arg1: Byte.
+//
+// READ_CHAR - 13 - Read a single byte of input.
+// - Returns the byte, or -1 at the end of the input.
+//
+// READ_STRING - 14 - Read a line of input into a NUL-terminated string. The
+// newline is kept if it fits.
+// - Arg1: Buffer address.
+// - Arg2: Size of the buffer in bytes, including the NUL.
+//
+// OPEN - 15 - Open a file. File calls are restricted to a directory chosen by
+// the user and fail if none is chosen.
+// - Arg1: Address of the NUL-terminated file name.
+// - Arg2: Flags: OPEN_READ, OPEN_WRITE (create or truncate) or OPEN_APPEND
+// (create or append).
+// - Returns the file descriptor, or -1 on errors.
+//
+// READ_FD - 16 - Read from a file descriptor (0 is the standard input).
+// - Arg1: File descriptor.
+// - Arg2: Buffer address.
+// - Arg3: Size of the buffer in bytes.
+// - Returns the number of bytes read (0 at the end of the file), or -1 on
+// errors.
+//
+// WRITE_FD - 17 - Write to a file descriptor (1 is the standard output and 2
+// the standard error).
+// - Arg1: File descriptor.
+// - Arg2: Buffer address.
+// - Arg3: Size in bytes of output.
+// - Returns the number of bytes written, or -1 on errors.
+//
+// CLOSE - 18 - Close a file descriptor.
+// - Arg1: File descriptor.
+// - Returns 0, or -1 on errors.
+//
+// LSEEK - 19 - Change the offset of a file descriptor.
+// - Arg1: File descriptor.
+// - Arg2: Signed offset.
+// - Arg3: Whence: SEEK_SET, SEEK_CUR or SEEK_END.
+// - Returns the new offset, or -1 on errors.
+//
+// Values are returned by writing the Return register. Values larger than the
+// word width of the machine are truncated and signed arguments are sign
+// extended from the word width.
+const (
+	SYS_BREAK        = 1
+	SYS_READ         = 2
+	SYS_WRITE        = 3
+	SYS_EXIT         = 4
+	SYS_PRINT_INT    = 5
+	SYS_PRINT_UINT   = 6
+	SYS_READ_INT     = 7
+	SYS_PRINT_STRING = 8
+	SYS_SBRK         = 9
+	SYS_TIME         = 10
+	SYS_RANDOM       = 11
+	SYS_PRINT_CHAR   = 12
+	SYS_READ_CHAR    = 13
+	SYS_READ_STRING  = 14
+	SYS_OPEN         = 15
+	SYS_READ_FD      = 16
+	SYS_WRITE_FD     = 17
+	SYS_CLOSE        = 18
+	SYS_LSEEK        = 19
+)
+
+// Flags of the OPEN call (the same as in RARS and MARS).
 const (
-	SYS_BREAK = 1
-	SYS_READ  = 2
-	SYS_WRITE = 3
+	OPEN_READ   = 0
+	OPEN_WRITE  = 1
+	OPEN_APPEND = 9
 )
 
+// Whences of the LSEEK call.
+const (
+	SEEK_SET = 0
+	SEEK_CUR = 1
+	SEEK_END = 2
+)
+
+// Names of the call numberings.
+const (
+	// The numbering above.
+	SYSCALLS_EGG = "egg"
+	// Numbering of the RARS RISC-V simulator.
+	SYSCALLS_RARS = "rars"
+	// Numbering of the MARS and SPIM MIPS simulators.
+	SYSCALLS_SPIM = "spim"
+)
+
+// Interface implemented by machines that may number their calls as other
+// emulators do, so programs written for them run unchanged. The calls are
+// translated to the EGG ones by the machine.
+type SyscallNumbering interface {
+	// Sets the call numbering. Returns an error if the machine does not
+	// support it.
+	SetSyscalls(numbering string) error
+}
+
 // Struct returned from NextInstruction() when a call is performed.
 type Call struct {
 	Number uint64
 	Arg1   uint64
 	Arg2   uint64
+	Arg3   uint64
+	// Register written by calls that return a value.
+	Return uint64
 }
 
 // Internationalization context available for architectures.
@@ -0,0 +1,47 @@
+package machine
+
+// Interface for receiving notifications about the memory and register accesses
+// performed by a machine. It's the base of debugger features such as reverse
+// execution and watchpoints, and may be used for tracing, coverage, cache
+// simulation, etc.
+//
+// Writes are notified by the backend setters, so they include writes performed
+// by LoadProgram, Restore and the debugger itself (e.g., the set command and
+// READ calls). Reads are only notified for load instructions: instruction
+// fetches and GetMemory calls from outside the machine are not notified.
+type Observer interface {
+	// Called before a load instruction reads size bytes starting at addr.
+	OnMemoryRead(addr uint64, size uint64)
+	// Called before a byte of memory is written.
+	OnMemoryWrite(addr uint64, old uint8, new uint8)
+	// Called before a register is written. Writes to registers that are
+	// hardwired (as x0 in RISC-V) are not notified.
+	OnRegisterWrite(reg uint64, old uint64, new uint64)
+}
+
+// Interface implemented by machines that notify an Observer.
+type Observable interface {
+	// Sets the observer of the machine. nil removes it.
+	SetObserver(Observer)
+}
+
+// Observers sends every notification to many observers, in order.
+type Observers []Observer
+
+func (o Observers) OnMemoryRead(addr uint64, size uint64) {
+	for _, observer := range o {
+		observer.OnMemoryRead(addr, size)
+	}
+}
+
+func (o Observers) OnMemoryWrite(addr uint64, old uint8, new uint8) {
+	for _, observer := range o {
+		observer.OnMemoryWrite(addr, old, new)
+	}
+}
+
+func (o Observers) OnRegisterWrite(reg uint64, old uint64, new uint64) {
+	for _, observer := range o {
+		observer.OnRegisterWrite(reg, old, new)
+	}
+}
@@ -0,0 +1,58 @@
+package machine
+
+import (
+	"math"
+	"math/bits"
+	"slices"
+)
+
+// Size of the pages tracked by DirtyPages.
+const PageSize = 4096
+
+// DirtyPages keeps track of which pages of a 32 bit address space were
+// written. Backends with 4 GiB of memory use it so they only need to clear (or
+// copy) the parts of memory actually touched by the program, instead of the
+// whole thing.
+type DirtyPages struct {
+	bits [(math.MaxUint32 + 1) / PageSize / 64]uint64
+}
+
+// Marks the pages between addr and addr+size as dirty.
+func (d *DirtyPages) Mark(addr uint64, size uint64) {
+	if size == 0 {
+		return
+	}
+	first := addr / PageSize
+	last := min((addr+size-1)/PageSize, uint64(len(d.bits)*64-1))
+	for p := first; p <= last; p++ {
+		d.bits[p/64] |= 1 << (p % 64)
+	}
+}
+
+// Calls f with the base address of every dirty page, in ascending order.
+func (d *DirtyPages) Each(f func(page uint64)) {
+	for i, word := range d.bits {
+		for word != 0 {
+			bit := uint64(bits.TrailingZeros64(word))
+			f((uint64(i)*64 + bit) * PageSize)
+			word &^= 1 << bit
+		}
+	}
+}
+
+// Copies the content of every dirty page of mem, for use in snapshots.
+func (d *DirtyPages) Copy(mem []uint8) []MemoryChunk {
+	chunks := []MemoryChunk{}
+	d.Each(func(page uint64) {
+		chunks = append(chunks, MemoryChunk{
+			Address: page,
+			Content: slices.Clone(mem[page : page+PageSize]),
+		})
+	})
+	return chunks
+}
+
+// Marks every page as clean again.
+func (d *DirtyPages) Clear() {
+	clear(d.bits[:])
+}
@@ -0,0 +1,29 @@
+package machine
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDirtyPages(t *testing.T) {
+	var d DirtyPages
+
+	d.Mark(PageSize-1, 2)
+	d.Mark(0xffffffff, 1)
+	d.Mark(5*PageSize, 0)
+
+	pages := []uint64{}
+	d.Each(func(page uint64) {
+		pages = append(pages, page)
+	})
+
+	expected := []uint64{0, PageSize, 0xffffffff - (PageSize - 1)}
+	if !reflect.DeepEqual(pages, expected) {
+		t.Fatalf("Wrong dirty pages: %v (expected %v)", pages, expected)
+	}
+
+	d.Clear()
+	d.Each(func(page uint64) {
+		t.Fatalf("Page 0x%x still dirty after clear", page)
+	})
+}
@@ -1,12 +1,17 @@
 package main
 
 import (
-	"bufio"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"log"
+	"maps"
 	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/gboncoffee/egg/assembler"
 	"github.com/gboncoffee/egg/machine"
@@ -21,6 +26,18 @@ import (
 
 const VERSION = "3.5.2"
 
+// Exit statuses.
+const (
+	EXECUTION_FAILED_STATUS = 1
+	STEP_LIMIT_STATUS       = 3
+	TIMEOUT_STATUS          = 4
+	// After being interrupted with SIGINT, as the shells do.
+	INTERRUPTED_STATUS = 130
+)
+
+// How often source files are checked for changes when watching them.
+const WATCH_INTERVAL = 500 * time.Millisecond
+
 // Put new architetures here... (main.go:/switch architeture)
 func listArchs() {
 	fmt.Println(machine.InterCtx.Get(`Currently supported architetures:
@@ -37,31 +54,291 @@ func version() {
 	fmt.Println(machine.InterCtx.Get("EGG - Emulador Genérico do Gabriel - version ") + VERSION)
 }
 
-func runMachine(m machine.Machine) {
-	for {
+// Limits for running a machine without the debugger. Zero means no limit.
+type runLimits struct {
+	steps   uint64
+	timeout time.Duration
+}
+
+// Returned by runMachine when the machine executes more instructions than
+// allowed.
+type StepLimitError struct {
+	Steps uint64
+}
+
+func (e StepLimitError) Error() string {
+	return fmt.Sprintf(machine.InterCtx.Get("exceeded the limit of %v instructions"), e.Steps)
+}
+
+// Returned by runMachine when the machine runs for longer than allowed.
+type TimeoutError struct {
+	Timeout time.Duration
+}
+
+func (e TimeoutError) Error() string {
+	return fmt.Sprintf(machine.InterCtx.Get("exceeded the time limit of %v"), e.Timeout)
+}
+
+// Runs until a BREAK or EXIT call, an error, a limit is exceeded, stop is set or
+// ctx is done, even if waiting for input. Returns the exit status of the
+// program, i.e., the argument of the EXIT call or 0.
+func runMachine(ctx context.Context, m machine.Machine, calls *callHandler, stop *atomic.Bool, limits runLimits) (int, error) {
+	// The deadline also stops calls waiting for input.
+	if limits.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, limits.timeout)
+		defer cancel()
+	}
+	calls.ctx = ctx
+	defer func() {
+		calls.ctx = nil
+	}()
+
+	for steps := uint64(0); !stop.Load(); steps++ {
+		// Read once, so the deadline cannot pass between the checks.
+		err := ctx.Err()
+		if errors.Is(err, context.DeadlineExceeded) {
+			return 0, TimeoutError{limits.timeout}
+		}
+		if err != nil {
+			break
+		}
+		if limits.steps != 0 && steps >= limits.steps {
+			return 0, StepLimitError{limits.steps}
+		}
+
 		call, err := m.NextInstruction()
 		if err != nil {
-			log.Printf(machine.InterCtx.Get("Instruction execution failed: %v\n"), err)
-			return
+			return 0, err
 		}
 
 		if call != nil {
 			switch call.Number {
 			case machine.SYS_BREAK:
-				return
-			case machine.SYS_READ:
-				// Lol I'm using 3 std modules just to read from stdin.
-				addr := call.Arg1
-				size := call.Arg2
-				buf := make([]uint8, size)
-				reader := bufio.NewReader(os.Stdin)
-				_, _ = io.ReadFull(reader, buf)
-				_ = m.SetMemoryChunk(addr, buf)
-			case machine.SYS_WRITE:
-				addr := call.Arg1
-				size := call.Arg2
-				buf, _ := m.GetMemoryChunk(addr, size)
-				fmt.Print(string(buf))
+				return 0, nil
+			case machine.SYS_EXIT:
+				return int(uint8(call.Arg1)), nil
+			default:
+				// Errors of calls stopped by ctx are handled
+				// in the next iteration.
+				err = calls.handle(call)
+				if err != nil && ctx.Err() == nil {
+					return 0, err
+				}
+			}
+		}
+	}
+
+	return 0, nil
+}
+
+// Reports an error returned by runMachine. Returns the exit status for it.
+func reportRunError(err error) int {
+	var stepLimit StepLimitError
+	var timeout TimeoutError
+	switch {
+	case errors.As(err, &stepLimit):
+		log.Println(err)
+		return STEP_LIMIT_STATUS
+	case errors.As(err, &timeout):
+		log.Println(err)
+		return TIMEOUT_STATUS
+	default:
+		log.Printf(machine.InterCtx.Get("Instruction execution failed: %v\n"), err)
+		return EXECUTION_FAILED_STATUS
+	}
+}
+
+// Stops the machine (by setting stop) at the first SIGINT. The next ones are
+// not handled anymore, so they kill the process even if the machine is blocked
+// in a READ call.
+func stopOnInterrupt(stop *atomic.Bool) {
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		signal.Stop(interrupt)
+		stop.Store(true)
+	}()
+}
+
+// Prints where the machine stopped after being interrupted.
+func reportInterrupted(m machine.Machine, sym []assembler.DebuggerToken) {
+	pc := m.GetCurrentInstructionAddress()
+	log.Printf(machine.InterCtx.Get("Interrupted at address 0x%x\n"), pc)
+
+	info := m.ArchitectureInfo()
+	for _, tok := range sym {
+		if tok.Address == pc {
+			log.Println(tokensToString([]assembler.DebuggerToken{tok}, &info)[0])
+			break
+		}
+	}
+}
+
+// Overrides the default memory layout of the machine with the addresses set by
+// the user in the command line (the fields of layout whose flags were set).
+func setLayout(m machine.Machine, layout machine.MemoryLayout) error {
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	if !set["text-base"] && !set["data-base"] && !set["stack-top"] && !set["gp"] && !set["entry"] {
+		return nil
+	}
+
+	setter, ok := m.(machine.LayoutSetter)
+	if !ok {
+		return errors.New(machine.InterCtx.Get("Memory layouts are not supported for the selected backend."))
+	}
+
+	l := setter.Layout()
+	if set["text-base"] {
+		l.FlatBase = layout.TextBase
+		l.TextBase = layout.TextBase
+	}
+	if set["data-base"] {
+		l.DataBase = layout.DataBase
+	}
+	if set["stack-top"] {
+		l.StackTop = layout.StackTop
+	}
+	if set["gp"] {
+		l.GlobalPointer = layout.GlobalPointer
+	}
+	if set["entry"] {
+		l.Entry = layout.Entry
+	}
+	return setter.SetLayout(l)
+}
+
+func loadReplay(calls *callHandler, fileName string) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return calls.inputs.load(f)
+}
+
+func modificationTimes(files []string) map[string]time.Time {
+	times := make(map[string]time.Time)
+	for _, file := range files {
+		// Files that cannot be stated (e.g., removed while being saved)
+		// have the zero time, so they're noticed when they come back.
+		info, err := os.Stat(file)
+		if err == nil {
+			times[file] = info.ModTime()
+		} else {
+			times[file] = time.Time{}
+		}
+	}
+	return times
+}
+
+// Polls the modification time of an Assembly file and every file it includes,
+// calling changed whenever one of them is saved. Returns when stop is closed.
+func watchFiles(fileName string, stop <-chan struct{}, changed func()) {
+	files, _ := assembler.SourceFiles(fileName)
+	times := modificationTimes(files)
+
+	ticker := time.NewTicker(WATCH_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if maps.Equal(times, modificationTimes(files)) {
+				continue
+			}
+
+			changed()
+			// The changes may have added or removed includes.
+			files, _ = assembler.SourceFiles(fileName)
+			times = modificationTimes(files)
+		}
+	}
+}
+
+// Assembles and loads the file again. Returns false in case of errors.
+func reloadProgram(m machine.Machine, calls *callHandler, fileName string) ([]assembler.DebuggerToken, bool) {
+	code, sym, err := m.Assemble(fileName)
+	if err != nil {
+		log.Println(err)
+		return nil, false
+	}
+
+	m.Reset()
+	err = m.LoadProgram(code)
+	if err != nil {
+		log.Printf(machine.InterCtx.Get("Error loading assembled program: %v\n"), err)
+		return nil, false
+	}
+	calls.reset(uint64(len(code)))
+
+	return sym, true
+}
+
+// Runs the machine, running it again from the start whenever the source files
+// change, even if it's waiting for input. Returns only after a SIGINT, with the
+// exit status for it.
+func runWatching(m machine.Machine, calls *callHandler, sym []assembler.DebuggerToken, fileName string, limits runLimits) int {
+	changed := make(chan struct{}, 1)
+	stopWatching := make(chan struct{})
+	defer close(stopWatching)
+	go watchFiles(fileName, stopWatching, func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	})
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	// Runs are stopped by cancelling their context instead.
+	var stop atomic.Bool
+	for {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			_, err := runMachine(ctx, m, calls, &stop, limits)
+			if err != nil {
+				reportRunError(err)
+			}
+			close(done)
+		}()
+
+		select {
+		case <-changed:
+			cancel()
+			<-done
+		case <-interrupt:
+			select {
+			case <-done:
+				// Already finished, so there's nothing to report.
+				cancel()
+				return INTERRUPTED_STATUS
+			default:
+			}
+			cancel()
+			<-done
+			reportInterrupted(m, sym)
+			return INTERRUPTED_STATUS
+		}
+
+		log.Println(machine.InterCtx.Get("Source files changed, running again."))
+		ok := false
+		for !ok {
+			sym, ok = reloadProgram(m, calls, fileName)
+			if !ok {
+				select {
+				case <-changed:
+				case <-interrupt:
+					return INTERRUPTED_STATUS
+				}
 			}
 		}
 	}
@@ -72,6 +349,16 @@ func main() {
 	var debug bool
 	var list bool
 	var ver bool
+	var watch bool
+	var syscalls string
+	var fsRoot string
+	var input string
+	var output string
+	var record string
+	var replay string
+	var env []string
+	var layout machine.MemoryLayout
+	var limits runLimits
 	var m machine.Machine
 
 	machine.InterCtx.Init()
@@ -89,6 +376,27 @@ func main() {
 	flag.BoolVar(&ver, "v", false, machine.InterCtx.Get("Show current version and quit (shorthand)."))
 	flag.BoolVar(&debug, "debug", false, machine.InterCtx.Get("Enter debugger upon startup."))
 	flag.BoolVar(&debug, "d", false, machine.InterCtx.Get("Enter debugger upon startup (shorthand)."))
+	flag.Uint64Var(&limits.steps, "max-steps", 0, machine.InterCtx.Get("Stop with an error after executing this many instructions (0 means no limit)."))
+	flag.DurationVar(&limits.timeout, "timeout", 0, machine.InterCtx.Get("Stop with an error after running for this long, e.g., 10s (0 means no limit)."))
+	flag.StringVar(&syscalls, "syscalls", machine.SYSCALLS_EGG, machine.InterCtx.Get("Select the call numbering: 'egg', 'rars' (RISC-V only) or 'spim' (MIPS only)."))
+	flag.StringVar(&fsRoot, "fs-root", "", machine.InterCtx.Get("Allow file calls to access files inside this directory."))
+	flag.StringVar(&input, "in", "", machine.InterCtx.Get("Read the program input from this file instead of the standard input."))
+	flag.StringVar(&output, "out", "", machine.InterCtx.Get("Write the program output to this file instead of the standard output."))
+	flag.StringVar(&record, "record", "", machine.InterCtx.Get("Record the input, time and random numbers got by the program to this file."))
+	flag.StringVar(&replay, "replay", "", machine.InterCtx.Get("Replay the input, time and random numbers recorded in this file."))
+	flag.BoolVar(&watch, "watch", false, machine.InterCtx.Get("Run the program again whenever the source files change."))
+	flag.Uint64Var(&layout.TextBase, "text-base", 0, machine.InterCtx.Get("Address of the .text section, or of the whole program if it has no sections (RISC-V, MIPS and PIÁ only)."))
+	flag.Uint64Var(&layout.DataBase, "data-base", 0, machine.InterCtx.Get("Address of the .data section (RISC-V, MIPS and PIÁ only)."))
+	flag.Uint64Var(&layout.StackTop, "stack-top", 0, machine.InterCtx.Get("Initial value of the stack pointer (RISC-V, MIPS and PIÁ only)."))
+	flag.Uint64Var(&layout.GlobalPointer, "gp", 0, machine.InterCtx.Get("Initial value of the global pointer (RISC-V and MIPS only)."))
+	flag.StringVar(&layout.Entry, "entry", machine.ENTRY_LABEL, machine.InterCtx.Get("Label where the execution starts, if it's defined (RISC-V, MIPS and PIÁ only)."))
+	flag.Func("env", machine.InterCtx.Get("Add a KEY=VALUE variable to the program environment (may be repeated)."), func(v string) error {
+		if !strings.Contains(v, "=") {
+			return errors.New(machine.InterCtx.Get("expected KEY=VALUE"))
+		}
+		env = append(env, v)
+		return nil
+	})
 
 	flag.Parse()
 
@@ -129,11 +437,36 @@ func main() {
 		os.Exit(1)
 	}
 
+	if syscalls != machine.SYSCALLS_EGG {
+		numbering, ok := m.(machine.SyscallNumbering)
+		if !ok {
+			log.Println(machine.InterCtx.Get("Call numberings are not supported for the selected backend."))
+			os.Exit(1)
+		}
+		err := numbering.SetSyscalls(syscalls)
+		if err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	err := setLayout(m, layout)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+
 	file := flag.Arg(0)
 	if file == "" {
 		log.Println(machine.InterCtx.Get("No Assembly file supplied."))
 		os.Exit(1)
 	}
+	// Everything after the file (and an optional "--") is passed to the
+	// program.
+	args := flag.Args()[1:]
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
 
 	code, sym, err := m.Assemble(file)
 	if err != nil {
@@ -141,20 +474,71 @@ func main() {
 		os.Exit(1)
 	}
 
+	m.Reset()
 	err = m.LoadProgram(code)
 	if err != nil {
 		log.Printf(machine.InterCtx.Get("Error loading assembled program: %v\n"), err)
 		os.Exit(1)
 	}
 
+	calls := newCallHandler(m, os.Stdin, os.Stdout, uint64(len(code)))
+	calls.fsRoot = fsRoot
+	err = calls.setArguments(args, env)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	err = calls.setInput(input)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	err = calls.setOutput(output)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	if replay != "" {
+		err = loadReplay(calls, replay)
+		if err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+	}
+	if record != "" {
+		f, err := os.Create(record)
+		if err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		err = calls.inputs.recordTo(f)
+		if err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+	}
+
 	if debug {
 		if sym == nil {
 			log.Println(machine.InterCtx.Get("Debugging is not supported for the selected backend."))
 			os.Exit(1)
 		}
 		// Hello fellow Acme user. Plumb this: debugger.go:/debugMachine
-		debugMachine(m, sym, code, file)
+		debugMachine(m, calls, sym, code, file)
+	} else if watch {
+		os.Exit(runWatching(m, calls, sym, file, limits))
 	} else {
-		runMachine(m)
+		var stop atomic.Bool
+		stopOnInterrupt(&stop)
+		status, err := runMachine(context.Background(), m, calls, &stop, limits)
+		if err != nil {
+			os.Exit(reportRunError(err))
+		}
+		if stop.Load() {
+			reportInterrupted(m, sym)
+			os.Exit(INTERRUPTED_STATUS)
+		}
+		os.Exit(status)
 	}
 }
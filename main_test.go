@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gboncoffee/egg/machine"
+	"github.com/gboncoffee/egg/reduxK"
+	"github.com/gboncoffee/egg/sagui"
+)
+
+func TestRunMachine(t *testing.T) {
+	machine.InterCtx.Init()
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Zeroed Sagui memory jumps to 0 forever, and 0x60 is a BREAK.
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		program []uint8
+		limits  runLimits
+		err     error
+	}{
+		{"break", context.Background(), []uint8{0x60}, runLimits{steps: 100}, nil},
+		{"step limit", context.Background(), nil, runLimits{steps: 100}, StepLimitError{100}},
+		{"timeout", context.Background(), nil, runLimits{timeout: time.Millisecond}, TimeoutError{time.Millisecond}},
+		{"cancelled", cancelled, nil, runLimits{}, nil},
+	}
+	for _, test := range tests {
+		// Run a few times, so races with the deadline show up.
+		for range 20 {
+			var m sagui.Sagui
+			_ = m.LoadProgram(test.program)
+			calls := newCallHandler(&m, io.MultiReader(), io.Discard, 0)
+
+			var stop atomic.Bool
+			status, err := runMachine(test.ctx, &m, calls, &stop, test.limits)
+			if status != 0 || err != test.err {
+				t.Fatalf("%v: got status %v and error %v, expected error %v", test.name, status, err, test.err)
+			}
+		}
+	}
+}
+
+func TestTimeoutWaitingForInput(t *testing.T) {
+	machine.InterCtx.Init()
+
+	// An ecall reading 4 bytes from an input that never comes.
+	m := reduxK.ReduxK()
+	_ = m.SetMemory(0, 0xb0)
+	_ = m.SetRegister(0, machine.SYS_READ)
+	_ = m.SetRegister(1, 0x10)
+	_ = m.SetRegister(2, 4)
+	in, out := io.Pipe()
+	defer out.Close()
+	calls := newCallHandler(m, in, io.Discard, 0)
+
+	var stop atomic.Bool
+	_, err := runMachine(context.Background(), m, calls, &stop, runLimits{timeout: 10 * time.Millisecond})
+	if !errors.As(err, &TimeoutError{}) {
+		t.Fatalf("got error %v, expected a timeout", err)
+	}
+}
+
+func TestRunMachineStops(t *testing.T) {
+	machine.InterCtx.Init()
+
+	// An ecall exiting with the status in r1.
+	m := reduxK.ReduxK()
+	_ = m.SetMemory(0, 0xb0)
+	_ = m.SetRegister(0, machine.SYS_EXIT)
+	_ = m.SetRegister(1, 42)
+	calls := newCallHandler(m, io.MultiReader(), io.Discard, 0)
+
+	var stop atomic.Bool
+	status, err := runMachine(context.Background(), m, calls, &stop, runLimits{steps: 1})
+	if status != 42 || err != nil {
+		t.Fatalf("Got status %v and error %v, expected status 44", status, err)
+	}
+
+	// Nothing is executed after stopping.
+	_ = m.SetCurrentInstructionAddress(0)
+	stop.Store(true)
+	status, err = runMachine(context.Background(), m, calls, &stop, runLimits{})
+	if status != 0 || err != nil || m.GetCurrentInstructionAddress() != 0 {
+		t.Fatalf("Ran after stopping: status %v, error %v, pc 0x%x", status, err, m.GetCurrentInstructionAddress())
+	}
+}
+
+func TestReportRunError(t *testing.T) {
+	machine.InterCtx.Init()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	tests := []struct {
+		err    error
+		status int
+	}{
+		{StepLimitError{10}, STEP_LIMIT_STATUS},
+		{TimeoutError{time.Second}, TIMEOUT_STATUS},
+		{fmt.Errorf("wrapped: %w", TimeoutError{time.Second}), TIMEOUT_STATUS},
+		{errors.New("misaligned instruction"), EXECUTION_FAILED_STATUS},
+	}
+	for _, test := range tests {
+		if status := reportRunError(test.err); status != test.status {
+			t.Errorf("Got status %v for %v, expected %v", status, test.err, test.status)
+		}
+	}
+}
@@ -2,6 +2,7 @@
 package mips
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"math"
@@ -23,6 +24,19 @@ type Mips struct {
 	registers [34]uint32
 	pc        uint32
 	mem       [math.MaxUint32 + 1]uint8
+	dirty     machine.DirtyPages
+	observer  machine.Observer
+	// Number calls as MARS and SPIM.
+	spim bool
+	// Memory layout set by the user, or nil for the default one.
+	layout *machine.MemoryLayout
+	// Where the assembled program is loaded and started, it's .data
+	// section and the address after it's end.
+	textBase uint32
+	dataBase uint32
+	data     []uint8
+	entry    uint32
+	end      uint32
 }
 
 //
@@ -407,7 +421,11 @@ func (m *Mips) executeJal(imm uint32) {
 
 func (m *Mips) executeLb(rs, rt uint8, off uint32) {
 	rsv64, _ := m.GetRegister(uint64(rs))
-	mem, _ := m.GetMemory(uint64(uint32(rsv64) + off))
+	addr := uint64(uint32(rsv64) + off)
+	if m.observer != nil {
+		m.observer.OnMemoryRead(addr, 1)
+	}
+	mem, _ := m.GetMemory(addr)
 
 	memb := mem & 0xff
 	sign := uint64(memb >> 7)
@@ -419,14 +437,22 @@ func (m *Mips) executeLb(rs, rt uint8, off uint32) {
 
 func (m *Mips) executeLbu(rs, rt uint8, off uint32) {
 	rsv64, _ := m.GetRegister(uint64(rs))
-	mem, _ := m.GetMemory(uint64(uint32(rsv64) + off))
+	addr := uint64(uint32(rsv64) + off)
+	if m.observer != nil {
+		m.observer.OnMemoryRead(addr, 1)
+	}
+	mem, _ := m.GetMemory(addr)
 
 	_ = m.SetRegister(uint64(rt), uint64(mem))
 }
 
 func (m *Mips) executeLh(rs, rt uint8, off uint32) {
 	rsv64, _ := m.GetRegister(uint64(rs))
-	memSlice, _ := m.GetMemoryChunk(uint64(uint32(rsv64)+off), 2)
+	addr := uint64(uint32(rsv64) + off)
+	if m.observer != nil {
+		m.observer.OnMemoryRead(addr, 2)
+	}
+	memSlice, _ := m.GetMemoryChunk(addr, 2)
 	mem := memSlice[0]
 	mem2 := memSlice[1]
 
@@ -440,7 +466,11 @@ func (m *Mips) executeLh(rs, rt uint8, off uint32) {
 
 func (m *Mips) executeLhu(rs, rt uint8, off uint32) {
 	rsv64, _ := m.GetRegister(uint64(rs))
-	memSlice, _ := m.GetMemoryChunk(uint64(uint32(rsv64)+off), 2)
+	addr := uint64(uint32(rsv64) + off)
+	if m.observer != nil {
+		m.observer.OnMemoryRead(addr, 2)
+	}
+	memSlice, _ := m.GetMemoryChunk(addr, 2)
 	mem := memSlice[0]
 	mem2 := memSlice[1]
 
@@ -449,7 +479,11 @@ func (m *Mips) executeLhu(rs, rt uint8, off uint32) {
 
 func (m *Mips) executeLw(rs, rt uint8, off uint32) {
 	rsv64, _ := m.GetRegister(uint64(rs))
-	memSlice, _ := m.GetMemoryChunk(uint64(uint32(rsv64)+off), 4)
+	addr := uint64(uint32(rsv64) + off)
+	if m.observer != nil {
+		m.observer.OnMemoryRead(addr, 4)
+	}
+	memSlice, _ := m.GetMemoryChunk(addr, 4)
 	mem := memSlice[0]
 	mem2 := memSlice[1]
 	mem3 := memSlice[2]
@@ -461,7 +495,11 @@ func (m *Mips) executeLw(rs, rt uint8, off uint32) {
 func (m *Mips) executeLwl(rs, rt uint8, off uint32) {
 	rsv64, _ := m.GetRegister(uint64(rs))
 	rtv64, _ := m.GetRegister(uint64(rt))
-	memSlice, _ := m.GetMemoryChunk(uint64(uint32(rsv64)+off), 2)
+	addr := uint64(uint32(rsv64) + off)
+	if m.observer != nil {
+		m.observer.OnMemoryRead(addr, 2)
+	}
+	memSlice, _ := m.GetMemoryChunk(addr, 2)
 	mem := memSlice[0]
 	mem2 := memSlice[1]
 
@@ -471,7 +509,11 @@ func (m *Mips) executeLwl(rs, rt uint8, off uint32) {
 func (m *Mips) executeLwr(rs, rt uint8, off uint32) {
 	rsv64, _ := m.GetRegister(uint64(rs))
 	rtv64, _ := m.GetRegister(uint64(rt))
-	memSlice, _ := m.GetMemoryChunk(uint64(uint32(rsv64)+off), 2)
+	addr := uint64(uint32(rsv64) + off)
+	if m.observer != nil {
+		m.observer.OnMemoryRead(addr, 2)
+	}
+	memSlice, _ := m.GetMemoryChunk(addr, 2)
 	mem := memSlice[0]
 	mem2 := memSlice[1]
 
@@ -515,10 +557,15 @@ func (m *Mips) execute(i uint32) (*machine.Call, error) {
 			m.pc += 4
 			return &call, nil
 		case 0b1100:
+			if m.spim {
+				return m.spimCall()
+			}
 			num, _ := m.GetRegister(2)
 			a1, _ := m.GetRegister(4)
 			a2, _ := m.GetRegister(5)
-			call := machine.Call{Number: num, Arg1: a1, Arg2: a2}
+			a3, _ := m.GetRegister(6)
+			// Number in v0, arguments in a0, a1 and a2, return in v0.
+			call := machine.Call{Number: num, Arg1: a1, Arg2: a2, Arg3: a3, Return: 2}
 			m.pc += 4
 			return &call, nil
 		default:
@@ -650,6 +697,9 @@ func (m *Mips) SetRegister(reg uint64, content uint64) error {
 	}
 
 	if reg != 0 {
+		if m.observer != nil {
+			m.observer.OnRegisterWrite(reg, uint64(m.registers[reg]), uint64(uint32(content)))
+		}
 		m.registers[reg] = uint32(content) // Overflow is a feature.
 	}
 
@@ -669,7 +719,11 @@ func (m *Mips) SetMemory(addr uint64, content uint8) error {
 		return fmt.Errorf(machine.InterCtx.Get("value %v bigger than maximum 32 bit address %v"), addr, math.MaxUint32)
 	}
 
+	if m.observer != nil {
+		m.observer.OnMemoryWrite(addr, m.mem[addr], content)
+	}
 	m.mem[addr] = content
+	m.dirty.Mark(addr, 1)
 
 	return nil
 }
@@ -689,7 +743,11 @@ func (m *Mips) SetMemoryChunk(addr uint64, content []uint8) error {
 		return fmt.Errorf(machine.InterCtx.Get("end address %v bigger than maximum 32 bit address %v"), end, math.MaxUint32)
 	}
 
+	m.dirty.Mark(addr, uint64(len(content)))
 	for _, b := range content {
+		if m.observer != nil {
+			m.observer.OnMemoryWrite(addr, m.mem[addr], b)
+		}
 		m.mem[addr] = b
 		addr++
 	}
@@ -697,9 +755,163 @@ func (m *Mips) SetMemoryChunk(addr uint64, content []uint8) error {
 	return nil
 }
 
-func (m *Mips) LoadProgram(program []uint8) error {
+// Translates a syscall numbered as in MARS and SPIM to the EGG call. The
+// number is in v0, the arguments in a0, a1 and a2, and the return in v0.
+func (m *Mips) spimCall() (*machine.Call, error) {
+	num := m.registers[2]
+	a0 := uint64(m.registers[4])
+	a1 := uint64(m.registers[5])
+	a2 := uint64(m.registers[6])
+
+	call := machine.Call{Return: 2}
+	switch num {
+	case 1: // print_int
+		call.Number, call.Arg1 = machine.SYS_PRINT_INT, a0
+	case 4: // print_string
+		call.Number, call.Arg1 = machine.SYS_PRINT_STRING, a0
+	case 5: // read_int
+		call.Number = machine.SYS_READ_INT
+	case 8: // read_string
+		call.Number, call.Arg1, call.Arg2 = machine.SYS_READ_STRING, a0, a1
+	case 9: // sbrk
+		call.Number, call.Arg1 = machine.SYS_SBRK, a0
+	case 10: // exit
+		call.Number = machine.SYS_EXIT
+	case 11: // print_char
+		call.Number, call.Arg1 = machine.SYS_PRINT_CHAR, a0
+	case 12: // read_char
+		call.Number = machine.SYS_READ_CHAR
+	case 13: // open (the mode is ignored)
+		call.Number, call.Arg1, call.Arg2 = machine.SYS_OPEN, a0, a1
+	case 14: // read
+		call.Number, call.Arg1, call.Arg2, call.Arg3 = machine.SYS_READ_FD, a0, a1, a2
+	case 15: // write
+		call.Number, call.Arg1, call.Arg2, call.Arg3 = machine.SYS_WRITE_FD, a0, a1, a2
+	case 16: // close
+		call.Number, call.Arg1 = machine.SYS_CLOSE, a0
+	case 17: // exit2
+		call.Number, call.Arg1 = machine.SYS_EXIT, a0
+	case 30: // time (MARS, only the lower word, returned in $a0)
+		call.Number, call.Return = machine.SYS_TIME, 4
+	case 36: // print unsigned int (MARS)
+		call.Number, call.Arg1 = machine.SYS_PRINT_UINT, a0
+	case 41: // random int (MARS, returned in $a0)
+		call.Number, call.Return = machine.SYS_RANDOM, 4
+	default:
+		return nil, fmt.Errorf(machine.InterCtx.Get("unsupported SPIM call: %v"), num)
+	}
+	m.pc += 4
+
+	return &call, nil
+}
+
+// Sets argc, argv and envp in a0, a1 and a2, with the stack pointer just below
+// them.
+func (m *Mips) SetArguments(args []string, env []string) error {
+	sp, argv, envp, err := machine.LayoutArguments(m, m.Layout().StackTop, 4, binary.LittleEndian, args, env)
+	if err != nil {
+		return err
+	}
+
+	_ = m.SetRegister(29, sp)
+	_ = m.SetRegister(4, uint64(len(args)))
+	_ = m.SetRegister(5, argv)
+	_ = m.SetRegister(6, envp)
+	return nil
+}
+
+func (m *Mips) Layout() machine.MemoryLayout {
+	if m.layout == nil {
+		return machine.DefaultLayout32
+	}
+	return *m.layout
+}
+
+func (m *Mips) SetLayout(l machine.MemoryLayout) error {
+	err := machine.CheckLayout32(l)
+	if err != nil {
+		return err
+	}
+	m.layout = &l
+	return nil
+}
+
+func (m *Mips) ProgramEnd() uint64 {
+	return uint64(m.end)
+}
+
+func (m *Mips) SetSyscalls(numbering string) error {
+	switch numbering {
+	case machine.SYSCALLS_EGG:
+		m.spim = false
+	case machine.SYSCALLS_SPIM:
+		m.spim = true
+	default:
+		return fmt.Errorf(machine.InterCtx.Get("unsupported call numbering: %v"), numbering)
+	}
+	return nil
+}
+
+func (m *Mips) Reset() {
+	m.dirty.Each(func(page uint64) {
+		clear(m.mem[page : page+machine.PageSize])
+	})
+	m.dirty.Clear()
+	m.registers = [34]uint32{}
 	m.pc = 0
-	return m.SetMemoryChunk(0, program)
+}
+
+func (m *Mips) Snapshot() machine.Snapshot {
+	s := machine.Snapshot{
+		Architecture: m.ArchitectureInfo().Name,
+		Registers:    make([]uint64, 32),
+		PC:           uint64(m.pc),
+		Extra:        []uint64{uint64(m.registers[HI]), uint64(m.registers[LO])},
+		Memory:       m.dirty.Copy(m.mem[:]),
+	}
+	for i := range s.Registers {
+		s.Registers[i] = uint64(m.registers[i])
+	}
+
+	return s
+}
+
+func (m *Mips) Restore(s machine.Snapshot) error {
+	err := machine.CheckSnapshot(s, m.ArchitectureInfo().Name, 32, 2, math.MaxUint32)
+	if err != nil {
+		return err
+	}
+
+	m.Reset()
+	for i, v := range s.Registers {
+		m.registers[i] = uint32(v)
+	}
+	m.registers[HI] = uint32(s.Extra[0])
+	m.registers[LO] = uint32(s.Extra[1])
+	m.pc = uint32(s.PC)
+	for _, chunk := range s.Memory {
+		err := m.SetMemoryChunk(chunk.Address, chunk.Content)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Loads the program at the base of it's text, and it's data, if any, at the
+// base of the data. Starts it at it's entry point, with the stack and global
+// pointers set as in the memory layout.
+func (m *Mips) LoadProgram(program []uint8) error {
+	layout := m.Layout()
+	m.pc = m.entry
+	_ = m.SetRegister(29, layout.StackTop)
+	_ = m.SetRegister(28, layout.GlobalPointer)
+	err := m.SetMemoryChunk(uint64(m.textBase), program)
+	if err != nil || len(m.data) == 0 {
+		return err
+	}
+	return m.SetMemoryChunk(uint64(m.dataBase), m.data)
 }
 
 func (m *Mips) NextInstruction() (*machine.Call, error) {
@@ -717,6 +929,19 @@ func (m *Mips) GetCurrentInstructionAddress() uint64 {
 	return uint64(m.pc)
 }
 
+func (m *Mips) SetCurrentInstructionAddress(addr uint64) error {
+	if addr > math.MaxUint32 {
+		return fmt.Errorf(machine.InterCtx.Get("value %v bigger than maximum 32 bit address %v"), addr, math.MaxUint32)
+	}
+
+	m.pc = uint32(addr)
+	return nil
+}
+
+func (m *Mips) SetObserver(o machine.Observer) {
+	m.observer = o
+}
+
 //
 // Most of the assembler is literally copied from the RISC-V.
 //
@@ -1213,7 +1438,7 @@ func assembleInstruction(code []uint8, addr int, t assembler.ResolvedToken) erro
 	case "seb", "seh":
 		bin, err = assembleSpecial3(t)
 	case "bltz", "bgez":
-		bin, err = assembleRegimm(t, addr)
+		bin, err = assembleRegimm(t, int(t.Address))
 	case "addi":
 		bin, err = assembleAddi(t)
 	case "addiu":
@@ -1231,13 +1456,13 @@ func assembleInstruction(code []uint8, addr int, t assembler.ResolvedToken) erro
 	case "sltiu":
 		bin, err = assembleSltiu(t)
 	case "beq":
-		bin, err = assembleBeq(t, addr)
+		bin, err = assembleBeq(t, int(t.Address))
 	case "bgtz":
-		bin, err = assembleBgtz(t, addr)
+		bin, err = assembleBgtz(t, int(t.Address))
 	case "blez":
-		bin, err = assembleBlez(t, addr)
+		bin, err = assembleBlez(t, int(t.Address))
 	case "bne":
-		bin, err = assembleBne(t, addr)
+		bin, err = assembleBne(t, int(t.Address))
 	case "break":
 		bin = 13
 	case "syscall":
@@ -1393,7 +1618,16 @@ func (m *Mips) Assemble(file string) ([]uint8, []assembler.DebuggerToken, error)
 		return nil, nil, err
 	}
 
-	resolvedTokens, debuggerTokens, err := assembler.ResolveTokens(tokens, func(i *assembler.Instruction) error {
+	layout := m.Layout()
+	options := assembler.ResolveOptions{
+		Base:      layout.FlatBase,
+		Sections:  true,
+		TextBase:  layout.TextBase,
+		DataBase:  layout.DataBase,
+		Entry:     layout.Entry,
+		Functions: assembler.HiLo(16),
+	}
+	program, err := assembler.ResolveProgram(tokens, options, func(i *assembler.Instruction) error {
 		i.Size = 4
 		return nil
 	}, translateArgs)
@@ -1402,12 +1636,22 @@ func (m *Mips) Assemble(file string) ([]uint8, []assembler.DebuggerToken, error)
 		return nil, nil, err
 	}
 
-	code, err := assemble(resolvedTokens)
+	code, err := assemble(program.Text)
 	if err != nil {
 		return nil, nil, err
 	}
+	data, err := assemble(program.Data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	m.textBase = uint32(program.TextBase)
+	m.dataBase = uint32(program.DataBase)
+	m.data = data
+	m.entry = uint32(program.Entry)
+	m.end = uint32(program.End)
 
-	return code, debuggerTokens, nil
+	return code, program.DebuggerTokens, nil
 }
 
 func (m *Mips) ArchitectureInfo() machine.ArchitectureInfo {
@@ -452,6 +452,7 @@ func TestIsa(t *testing.T) {
 		Number: machine.SYS_READ,
 		Arg1:   1,
 		Arg2:   2,
+		Return: 2,
 	}
 	if *call != expectedCall {
 		t.Fatalf("syscall failed: %v", call)
@@ -574,3 +575,45 @@ func TestIsa(t *testing.T) {
 		t.Fatalf("sw failed: %v", slice)
 	}
 }
+
+func TestSpimCalls(t *testing.T) {
+	var m Mips
+	err := m.SetSyscalls(machine.SYSCALLS_SPIM)
+	if err != nil {
+		t.Fatalf("Error setting SPIM calls: %v", err)
+	}
+
+	// syscall.
+	syscall := []uint8{0x0c, 0, 0, 0}
+	tests := []struct {
+		v0       uint64
+		expected machine.Call
+	}{
+		{1, machine.Call{Number: machine.SYS_PRINT_INT, Arg1: 1, Return: 2}},
+		{8, machine.Call{Number: machine.SYS_READ_STRING, Arg1: 1, Arg2: 2, Return: 2}},
+		{9, machine.Call{Number: machine.SYS_SBRK, Arg1: 1, Return: 2}},
+		{10, machine.Call{Number: machine.SYS_EXIT, Return: 2}},
+		{17, machine.Call{Number: machine.SYS_EXIT, Arg1: 1, Return: 2}},
+		{30, machine.Call{Number: machine.SYS_TIME, Return: 4}},
+		{41, machine.Call{Number: machine.SYS_RANDOM, Return: 4}},
+	}
+	for _, test := range tests {
+		m.Reset()
+		_ = m.LoadProgram(syscall)
+		_ = m.SetRegister(2, test.v0)
+		_ = m.SetRegister(4, 1)
+		_ = m.SetRegister(5, 2)
+		call, err := m.NextInstruction()
+		if err != nil || *call != test.expected {
+			t.Fatalf("Wrong call for SPIM %v: %v, %v", test.v0, call, err)
+		}
+	}
+
+	m.Reset()
+	_ = m.LoadProgram(syscall)
+	_ = m.SetRegister(2, 1000)
+	_, err = m.NextInstruction()
+	if err == nil {
+		t.Fatalf("No error for unsupported SPIM call")
+	}
+}
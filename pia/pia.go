@@ -17,6 +17,17 @@ type Pia struct {
 	registers [16]uint32
 	pc        uint32
 	mem       [math.MaxUint32 + 1]uint8
+	dirty     machine.DirtyPages
+	observer  machine.Observer
+	// Memory layout set by the user, or nil for the default one.
+	layout *machine.MemoryLayout
+	// Where the assembled program is loaded and started, it's .data
+	// section and the address after it's end.
+	textBase uint32
+	dataBase uint32
+	data     []uint8
+	entry    uint32
+	end      uint32
 }
 
 // Helper function to sign-extend a value from n bits to 32 bits
@@ -43,10 +54,16 @@ func (m *Pia) writeWord(addr uint32, val uint32) error {
 	if addr > math.MaxUint32-3 {
 		return fmt.Errorf(machine.InterCtx.Get("value %v bigger than maximum 32 bit address %v"), addr)
 	}
+	if m.observer != nil {
+		for i := range uint32(4) {
+			m.observer.OnMemoryWrite(uint64(addr+i), m.mem[addr+i], uint8(val>>(8*i)))
+		}
+	}
 	m.mem[addr] = uint8(val)
 	m.mem[addr+1] = uint8(val >> 8)
 	m.mem[addr+2] = uint8(val >> 16)
 	m.mem[addr+3] = uint8(val >> 24)
+	m.dirty.Mark(uint64(addr), 4)
 	return nil
 }
 
@@ -63,14 +80,99 @@ func (m *Pia) writeHalfword(addr uint32, val uint16) error {
 	if addr > math.MaxUint32-1 {
 		return fmt.Errorf(machine.InterCtx.Get("value %v bigger than maximum 32 bit address %v"), addr)
 	}
+	if m.observer != nil {
+		m.observer.OnMemoryWrite(uint64(addr), m.mem[addr], uint8(val))
+		m.observer.OnMemoryWrite(uint64(addr+1), m.mem[addr+1], uint8(val>>8))
+	}
 	m.mem[addr] = uint8(val)
 	m.mem[addr+1] = uint8(val >> 8)
+	m.dirty.Mark(uint64(addr), 2)
 	return nil
 }
 
-func (m *Pia) LoadProgram(program []uint8) error {
+// Helper function to write a register, notifying the observer
+func (m *Pia) writeRegister(reg uint8, val uint32) {
+	if m.observer != nil {
+		m.observer.OnRegisterWrite(uint64(reg), uint64(m.registers[reg]), uint64(val))
+	}
+	m.registers[reg] = val
+}
+
+func (m *Pia) Reset() {
+	m.dirty.Each(func(page uint64) {
+		clear(m.mem[page : page+machine.PageSize])
+	})
+	m.dirty.Clear()
+	m.registers = [16]uint32{}
 	m.pc = 0
-	return m.SetMemoryChunk(0, program)
+}
+
+func (m *Pia) Snapshot() machine.Snapshot {
+	s := machine.Snapshot{
+		Architecture: m.ArchitectureInfo().Name,
+		Registers:    make([]uint64, 16),
+		PC:           uint64(m.pc),
+		Memory:       m.dirty.Copy(m.mem[:]),
+	}
+	for i := range s.Registers {
+		s.Registers[i] = uint64(m.registers[i])
+	}
+
+	return s
+}
+
+func (m *Pia) Restore(s machine.Snapshot) error {
+	err := machine.CheckSnapshot(s, m.ArchitectureInfo().Name, 16, 0, math.MaxUint32)
+	if err != nil {
+		return err
+	}
+
+	m.Reset()
+	for i, v := range s.Registers {
+		m.registers[i] = uint32(v)
+	}
+	m.pc = uint32(s.PC)
+	for _, chunk := range s.Memory {
+		err := m.SetMemoryChunk(chunk.Address, chunk.Content)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Loads the program at the base of it's text, and it's data, if any, at the
+// base of the data. Starts it at it's entry point, with the stack pointer set as
+// in the memory layout. PIÁ has no global pointer, so it's not used.
+func (m *Pia) LoadProgram(program []uint8) error {
+	m.pc = m.entry
+	_ = m.SetRegister(1, m.Layout().StackTop)
+	err := m.SetMemoryChunk(uint64(m.textBase), program)
+	if err != nil || len(m.data) == 0 {
+		return err
+	}
+	return m.SetMemoryChunk(uint64(m.dataBase), m.data)
+}
+
+func (m *Pia) Layout() machine.MemoryLayout {
+	if m.layout == nil {
+		return machine.DefaultLayout32
+	}
+	return *m.layout
+}
+
+func (m *Pia) SetLayout(l machine.MemoryLayout) error {
+	err := machine.CheckLayout32(l)
+	if err != nil {
+		return err
+	}
+	m.layout = &l
+	return nil
+}
+
+func (m *Pia) ProgramEnd() uint64 {
+	return uint64(m.end)
 }
 
 // Instruction type constants (based on opcode[3:0])
@@ -228,7 +330,7 @@ func (m *Pia) executeC(instr uint16) (*machine.Call, error) {
 		val = int32(^uint32(val))
 	}
 
-	m.registers[rd] = uint32(val)
+	m.writeRegister(rd, uint32(val))
 	m.pc += 2
 	return nil, nil
 }
@@ -303,7 +405,7 @@ func (m *Pia) executeR(rd uint8, rs uint8, rsv uint32, rdv uint32, func_ uint8)
 		return nil, fmt.Errorf("reserved instruction")
 	}
 
-	m.registers[rd] = result
+	m.writeRegister(rd, result)
 	m.pc += 2
 	return nil, nil
 }
@@ -354,7 +456,7 @@ func (m *Pia) executeF(rd uint8, rs uint8, rsv uint32, rdv uint32, func_ uint8)
 		return nil, fmt.Errorf("reserved instruction")
 	}
 
-	m.registers[rd] = result
+	m.writeRegister(rd, result)
 	m.pc += 2
 	return nil, nil
 }
@@ -366,23 +468,32 @@ func (m *Pia) executeLS(instr uint16) (*machine.Call, error) {
 
 	switch func_ {
 	case 0x0: // lb - Load byte
+		if m.observer != nil {
+			m.observer.OnMemoryRead(uint64(rsv), 1)
+		}
 		val, err := m.GetMemory(uint64(rsv))
 		if err != nil {
 			return nil, err
 		}
-		m.registers[rd] = uint32(int32(int8(val)))
+		m.writeRegister(rd, uint32(int32(int8(val))))
 	case 0x1: // lh - Load halfword
+		if m.observer != nil {
+			m.observer.OnMemoryRead(uint64(rsv), 2)
+		}
 		hw, err := m.readHalfword(rsv)
 		if err != nil {
 			return nil, err
 		}
-		m.registers[rd] = uint32(int32(int16(hw)))
+		m.writeRegister(rd, uint32(int32(int16(hw))))
 	case 0x2: // lw - Load word
+		if m.observer != nil {
+			m.observer.OnMemoryRead(uint64(rsv), 4)
+		}
 		w, err := m.readWord(rsv)
 		if err != nil {
 			return nil, err
 		}
-		m.registers[rd] = w
+		m.writeRegister(rd, w)
 	case 0x4: // sb - Store byte
 		err := m.SetMemory(uint64(rsv), uint8(m.registers[rd]))
 		if err != nil {
@@ -402,11 +513,11 @@ func (m *Pia) executeLS(instr uint16) (*machine.Call, error) {
 		m.pc = rsv
 		return nil, nil
 	case 0x9: // jlr - Jump and link register
-		m.registers[0] = m.pc + 2 // ra = PC + 2
+		m.writeRegister(0, m.pc+2) // ra = PC + 2
 		m.pc = rsv
 		return nil, nil
 	case 0xF: // lear - Load Effective Address Register
-		m.registers[rd] = rsv
+		m.writeRegister(rd, rsv)
 	default:
 		m.pc += 2
 		return nil, nil
@@ -416,6 +527,19 @@ func (m *Pia) executeLS(instr uint16) (*machine.Call, error) {
 	return nil, nil
 }
 
+// Creates the call for syscall and sysrcall: the number is the immediate of
+// syscall or ta, the arguments in tb, tc and td and the return in ta.
+func (m *Pia) syscall(number uint64) *machine.Call {
+	m.pc += 2
+	return &machine.Call{
+		Number: number,
+		Arg1:   uint64(m.registers[9]),
+		Arg2:   uint64(m.registers[10]),
+		Arg3:   uint64(m.registers[11]),
+		Return: 8,
+	}
+}
+
 // Execute format S instructions (16-bit special)
 func (m *Pia) executeS(instr uint16) (*machine.Call, error) {
 	imm, func_, _ := parseS16(instr)
@@ -432,9 +556,9 @@ func (m *Pia) executeS(instr uint16) (*machine.Call, error) {
 		m.pc += 2
 		return nil, nil
 	case 0xA: // syscall - System call
-		return &machine.Call{Number: uint64(imm)}, nil
+		return m.syscall(uint64(imm)), nil
 	case 0x0: // sysrcall - System call (via ta)
-		return &machine.Call{Number: uint64(m.registers[8])}, nil
+		return m.syscall(uint64(m.registers[8])), nil
 	case 0x1: // movcr2ta - Move control register to ta
 		// Control registers not implemented yet
 		m.pc += 2
@@ -444,7 +568,7 @@ func (m *Pia) executeS(instr uint16) (*machine.Call, error) {
 		m.pc += 2
 		return nil, nil
 	case 0xD: // fwid - Get firmware ID
-		m.registers[8] = 0xE99
+		m.writeRegister(8, 0xE99)
 		m.pc += 2
 		return nil, nil
 	}
@@ -461,47 +585,47 @@ func (m *Pia) executeI(instr uint32) (*machine.Call, error) {
 
 	switch (instr >> 8) & 0xF {
 	case 0x0: // addi
-		m.registers[rd] = uint32(rdv + simm)
+		m.writeRegister(rd, uint32(rdv+simm))
 	case 0x1: // slli
-		m.registers[rd] = uint32(uint32(rdv) << uint32(simm&0x1F))
+		m.writeRegister(rd, uint32(uint32(rdv)<<uint32(simm&0x1F)))
 	case 0x2: // srli
-		m.registers[rd] = uint32(uint32(rdv) >> uint32(simm&0x1F))
+		m.writeRegister(rd, uint32(uint32(rdv)>>uint32(simm&0x1F)))
 	case 0x3: // andi
-		m.registers[rd] = uint32(rdv) & imm
+		m.writeRegister(rd, uint32(rdv)&imm)
 	case 0x4: // ori
-		m.registers[rd] = uint32(rdv) | imm
+		m.writeRegister(rd, uint32(rdv)|imm)
 	case 0x5: // xori
-		m.registers[rd] = uint32(rdv) ^ imm
+		m.writeRegister(rd, uint32(rdv)^imm)
 	case 0x6: // addiu
-		m.registers[rd] = uint32(rdv) + imm
+		m.writeRegister(rd, uint32(rdv)+imm)
 	case 0x7: // muli
-		m.registers[rd] = uint32(rdv * simm)
+		m.writeRegister(rd, uint32(rdv*simm))
 	case 0x8: // mului
-		m.registers[rd] = uint32(rdv) * imm
+		m.writeRegister(rd, uint32(rdv)*imm)
 	case 0x9: // divi
 		if simm == 0 {
-			m.registers[rd] = 0
+			m.writeRegister(rd, 0)
 		} else {
-			m.registers[rd] = uint32(rdv / simm)
+			m.writeRegister(rd, uint32(rdv/simm))
 		}
 	case 0xA: // divui
 		if imm == 0 {
-			m.registers[rd] = 0
+			m.writeRegister(rd, 0)
 		} else {
-			m.registers[rd] = uint32(rdv) / imm
+			m.writeRegister(rd, uint32(rdv)/imm)
 		}
 	case 0xB: // modi
 		if simm == 0 {
-			m.registers[rd] = 0
+			m.writeRegister(rd, 0)
 		} else {
-			m.registers[rd] = uint32(rdv % simm)
+			m.writeRegister(rd, uint32(rdv%simm))
 		}
 	case 0xC: // movi
-		m.registers[rd] = uint32(simm)
+		m.writeRegister(rd, uint32(simm))
 	case 0xD: // lui - Load upper immediate
-		m.registers[rd] = (uint32(m.registers[rd]) & 0xFFFF) | ((imm & 0xFFFF) << 16)
+		m.writeRegister(rd, (uint32(m.registers[rd])&0xFFFF)|((imm&0xFFFF)<<16))
 	case 0xE: // leai - Load effective address (PC + imm)
-		m.registers[rd] = uint32(int32(m.pc) + simm)
+		m.writeRegister(rd, uint32(int32(m.pc)+simm))
 	}
 
 	m.pc += 4
@@ -555,7 +679,7 @@ func (m *Pia) executeJ(instr uint32) (*machine.Call, error) {
 		case 0: // lj - Long jump (absolute)
 			m.pc = uint32(simm)
 		case 1: // ljl - Long jump and link
-			m.registers[0] = m.pc + 4
+			m.writeRegister(0, m.pc+4)
 			m.pc = uint32(simm)
 		}
 	case 0xE:
@@ -563,7 +687,7 @@ func (m *Pia) executeJ(instr uint32) (*machine.Call, error) {
 		case 0: // lrj - Long relative jump
 			m.pc = uint32(int32(m.pc) + simm)
 		case 1: // lrjl - Long relative jump and link
-			m.registers[0] = m.pc + 4
+			m.writeRegister(0, m.pc+4)
 			m.pc = uint32(int32(m.pc) + simm)
 		}
 	}
@@ -581,7 +705,11 @@ func (m *Pia) SetMemory(addr uint64, content uint8) error {
 	if addr > math.MaxUint32 {
 		return fmt.Errorf(machine.InterCtx.Get("value %v bigger than maximum 32 bit address %v"), addr, math.MaxUint32)
 	}
+	if m.observer != nil {
+		m.observer.OnMemoryWrite(addr, m.mem[addr], content)
+	}
 	m.mem[addr] = content
+	m.dirty.Mark(addr, 1)
 	return nil
 }
 
@@ -598,7 +726,13 @@ func (m *Pia) SetMemoryChunk(addr uint64, content []uint8) error {
 	if end > math.MaxUint32 {
 		return fmt.Errorf(machine.InterCtx.Get("value %v bigger than maximum 32 bit address %v"), addr, math.MaxUint32)
 	}
+	if m.observer != nil {
+		for i, b := range content {
+			m.observer.OnMemoryWrite(addr+uint64(i), m.mem[addr+uint64(i)], b)
+		}
+	}
 	copy(m.mem[addr:end+1], content)
+	m.dirty.Mark(addr, uint64(len(content)))
 	return nil
 }
 
@@ -616,7 +750,7 @@ func (m *Pia) SetRegister(reg uint64, content uint64) error {
 	if content > math.MaxUint32 {
 		return fmt.Errorf(machine.InterCtx.Get("value %v bigger than maximum 32 bit unsigned value %v"), content, math.MaxUint32)
 	}
-	m.registers[reg] = uint32(content)
+	m.writeRegister(uint8(reg), uint32(content))
 	return nil
 }
 
@@ -1210,23 +1344,54 @@ func (m *Pia) Assemble(file string) ([]uint8, []assembler.DebuggerToken, error)
 		return nil, nil, err
 	}
 
-	resolvedTokens, debuggerTokens, err := assembler.ResolveTokens(tokens, processPiaInstruction, translatePiaArgs)
+	layout := m.Layout()
+	options := assembler.ResolveOptions{
+		Base:     layout.FlatBase,
+		Sections: true,
+		TextBase: layout.TextBase,
+		DataBase: layout.DataBase,
+		Entry:    layout.Entry,
+	}
+	program, err := assembler.ResolveProgram(tokens, options, processPiaInstruction, translatePiaArgs)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	code, err := assemblePia(resolvedTokens)
+	code, err := assemblePia(program.Text)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := assemblePia(program.Data)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	return code, debuggerTokens, nil
+	m.textBase = uint32(program.TextBase)
+	m.dataBase = uint32(program.DataBase)
+	m.data = data
+	m.entry = uint32(program.Entry)
+	m.end = uint32(program.End)
+
+	return code, program.DebuggerTokens, nil
 }
 
 func (m *Pia) GetCurrentInstructionAddress() uint64 {
 	return uint64(m.pc)
 }
 
+func (m *Pia) SetCurrentInstructionAddress(addr uint64) error {
+	if addr > math.MaxUint32 {
+		return fmt.Errorf(machine.InterCtx.Get("value %v bigger than maximum 32 bit address %v"), addr, math.MaxUint32)
+	}
+
+	m.pc = uint32(addr)
+	return nil
+}
+
+func (m *Pia) SetObserver(o machine.Observer) {
+	m.observer = o
+}
+
 func (m *Pia) ArchitectureInfo() machine.ArchitectureInfo {
 	return machine.ArchitectureInfo{
 		Name:      "PIÁ - Processador de Informação Avançado",
@@ -0,0 +1,60 @@
+package pia
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gboncoffee/egg/assembler"
+	"github.com/gboncoffee/egg/machine"
+)
+
+func TestLayout(t *testing.T) {
+	machine.InterCtx.Init()
+	assembler.InterCtx = &machine.InterCtx
+
+	var m Pia
+	err := m.SetLayout(machine.MemoryLayout{FlatBase: 0x400, TextBase: 0x1000, DataBase: 0x2000, StackTop: 0x8000, Entry: "main"})
+	if err != nil {
+		t.Fatalf("Error setting layout: %v", err)
+	}
+	if m.SetLayout(machine.MemoryLayout{TextBase: 2}) == nil {
+		t.Fatalf("Misaligned text base accepted")
+	}
+
+	programs := []struct {
+		source string
+		entry  uint64
+		// Address of the first byte of the data.
+		data uint64
+		end  uint64
+	}{
+		{"addsi ta, 1\nmain:\naddsi tb, 2\n#%07\n", 0x402, 0x404, 0x405},
+		{".data\n.bits32 7\n.text\naddsi ta, 1\nmain:\naddsi tb, 2\n", 0x1002, 0x2000, 0x2008},
+	}
+	for _, program := range programs {
+		file := filepath.Join(t.TempDir(), "layout.asm")
+		err = os.WriteFile(file, []byte(program.source), 0644)
+		if err != nil {
+			t.Fatalf("Error writing file: %v", err)
+		}
+		code, _, err := m.Assemble(file)
+		if err != nil {
+			t.Fatalf("Couldn't assemble %q: %v", program.source, err)
+		}
+		m.Reset()
+		err = m.LoadProgram(code)
+		if err != nil {
+			t.Fatalf("Couldn't load %q: %v", program.source, err)
+		}
+
+		sp, _ := m.GetRegister(1)
+		data, _ := m.GetMemory(program.data)
+		if m.GetCurrentInstructionAddress() != program.entry || sp != 0x8000 || data != 7 {
+			t.Fatalf("Wrong initial state of %q: pc 0x%x, sp 0x%x, data %v", program.source, m.GetCurrentInstructionAddress(), sp, data)
+		}
+		if m.ProgramEnd() != program.end {
+			t.Fatalf("Wrong end of %q: 0x%x", program.source, m.ProgramEnd())
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/gboncoffee/egg/machine"
+)
+
+// Something a program got from outside of the machine with a call: input,
+// the time or a random number.
+type inputEvent struct {
+	Call  uint64 `json:"call"`
+	Data  []byte `json:"data,omitempty"`
+	Value uint64 `json:"value,omitempty"`
+	EOF   bool   `json:"eof,omitempty"`
+}
+
+// Every input event of a run, so they can be replayed in the next runs. While
+// there are events to replay, calls use them instead of the real input. After
+// that, the new events are appended if they're kept.
+type inputLog struct {
+	events []inputEvent
+	// Position of the next event to replay.
+	next int
+	// Where new events are recorded to, or nil.
+	record *json.Encoder
+	// Keep new events in memory, so they're replayed after rewinding. Only
+	// needed by the debugger and when recording, as other runs never
+	// rewind and the log would grow forever with programs reading a lot.
+	keep bool
+}
+
+// Loads events to replay, in the format written by recordTo (one JSON object
+// per line).
+func (l *inputLog) load(r io.Reader) error {
+	decoder := json.NewDecoder(r)
+	for {
+		var e inputEvent
+		err := decoder.Decode(&e)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf(machine.InterCtx.Get("malformed replay file: %v"), err)
+		}
+		l.events = append(l.events, e)
+	}
+}
+
+// Writes every event (the ones already in the log and the next ones) to w.
+func (l *inputLog) recordTo(w io.Writer) error {
+	l.record = json.NewEncoder(w)
+	l.keep = true
+	for _, e := range l.events {
+		err := l.record.Encode(e)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Starts replaying the events from the first one.
+func (l *inputLog) rewind() {
+	l.next = 0
+}
+
+// Forgets every event, e.g., because the input changed.
+func (l *inputLog) clear() {
+	l.events = nil
+	l.next = 0
+}
+
+func (l *inputLog) replaying() bool {
+	return l.next < len(l.events)
+}
+
+// Returns the next event to replay, if there's one for the call. When the
+// program performs a different call than the one recorded, the rest of the
+// events are forgotten and an error is returned, so the caller may warn and
+// use the real input.
+func (l *inputLog) replay(call uint64) (inputEvent, bool, error) {
+	if !l.replaying() {
+		return inputEvent{}, false, nil
+	}
+
+	e := l.events[l.next]
+	if e.Call != call {
+		l.events = l.events[:l.next]
+		return inputEvent{}, false, fmt.Errorf(machine.InterCtx.Get("recorded call %v but the program performed call %v, not replaying anymore"), e.Call, call)
+	}
+
+	l.next++
+	return e, true, nil
+}
+
+// Appends a new event, if they're kept, and records it.
+func (l *inputLog) add(e inputEvent) error {
+	if l.keep {
+		l.events = append(l.events, e)
+		l.next = len(l.events)
+	}
+	if l.record != nil {
+		return l.record.Encode(e)
+	}
+	return nil
+}
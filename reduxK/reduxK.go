@@ -16,6 +16,28 @@ type ReduxKExtState struct {
 	auxRegisters [2]uint8
 }
 
+// The auxiliary registers are registers 4 and 5 of the machine.
+func (s *ReduxKExtState) Registers() []uint8 {
+	return s.auxRegisters[:]
+}
+
+func (s *ReduxKExtState) Reset() {
+	s.auxRegisters = [2]uint8{}
+}
+
+func (s *ReduxKExtState) Snapshot() []uint64 {
+	return []uint64{uint64(s.auxRegisters[0]), uint64(s.auxRegisters[1])}
+}
+
+func (s *ReduxKExtState) Restore(state []uint64) error {
+	if len(state) != len(s.auxRegisters) {
+		return errors.New(machine.InterCtx.Get("malformed snapshot"))
+	}
+	s.auxRegisters[0] = uint8(state[0])
+	s.auxRegisters[1] = uint8(state[1])
+	return nil
+}
+
 func reduxKExecuteExtension(bin uint8, m *reduxc.ReduxC) (bool, *machine.Call, error) {
 	op := bin >> 4
 	ext := m.AdditionalState().(*ReduxKExtState)
@@ -38,12 +60,12 @@ func reduxKExecuteExtension(bin uint8, m *reduxc.ReduxC) (bool, *machine.Call, e
 		*m.PC() = *m.PC() + 1
 		for x := int8(0); x < int8(bin&0xf); x++ {
 			r0v, _ := m.GetRegister(0)
-			memr0, _ := m.GetMemory(r0v)
-			ext.auxRegisters[0] = memr0
+			memr0, _ := m.LoadMemory(r0v)
+			_ = m.SetRegister(4, uint64(memr0))
 
 			r1v, _ := m.GetRegister(1)
-			memr1, _ := m.GetMemory(r1v)
-			ext.auxRegisters[1] = memr1
+			memr1, _ := m.LoadMemory(r1v)
+			_ = m.SetRegister(5, uint64(memr1))
 
 			rx := ext.auxRegisters[0]
 			ry := ext.auxRegisters[1]
@@ -88,10 +110,14 @@ func reduxKExecuteExtension(bin uint8, m *reduxc.ReduxC) (bool, *machine.Call, e
 			r0v, _ := m.GetRegister(0)
 			r1v, _ := m.GetRegister(1)
 			r2v, _ := m.GetRegister(2)
+			r3v, _ := m.GetRegister(3)
+			// Number in r0, arguments in r1, r2 and r3, return in r0.
 			return true, &machine.Call{
 				Number: r0v,
 				Arg1:   r1v,
 				Arg2:   r2v,
+				Arg3:   r3v,
+				Return: 0,
 			}, nil
 		}
 	}
@@ -1,6 +1,9 @@
 package reduxK
 
 import (
+	"os"
+	"path/filepath"
+	"slices"
 	"testing"
 
 	"github.com/gboncoffee/egg/assembler"
@@ -196,3 +199,138 @@ func TestReduxK(t *testing.T) {
 	}
 
 }
+
+func TestReset(t *testing.T) {
+	m := ReduxK()
+	code, _, err := m.Assemble("test.asm")
+	if err != nil {
+		t.Fatalf("Couldn't assemble: %v", err)
+	}
+
+	_ = m.LoadProgram(code)
+	for range 64 {
+		_, _ = m.NextInstruction()
+	}
+	m.AdditionalState().(*ReduxKExtState).auxRegisters[0] = 42
+
+	m.Reset()
+
+	for i := range 4 {
+		r, _ := m.GetRegister(uint64(i))
+		if r != 0 {
+			t.Fatalf("Register %v not cleared by reset: %v", i, r)
+		}
+	}
+	if m.GetCurrentInstructionAddress() != 0 {
+		t.Fatalf("PC not cleared by reset")
+	}
+	for addr := range 256 {
+		mem, _ := m.GetMemory(uint64(addr))
+		if mem != 0 {
+			t.Fatalf("Memory at 0x%x not cleared by reset: %v", addr, mem)
+		}
+	}
+	if m.AdditionalState().(*ReduxKExtState).auxRegisters != [2]uint8{} {
+		t.Fatalf("Auxiliary registers not cleared by reset")
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	m := ReduxK()
+	code, _, err := m.Assemble("test.asm")
+	if err != nil {
+		t.Fatalf("Couldn't assemble: %v", err)
+	}
+
+	_ = m.LoadProgram(code)
+	for range 16 {
+		_, _ = m.NextInstruction()
+	}
+	m.AdditionalState().(*ReduxKExtState).auxRegisters[1] = 42
+	_ = m.SetMemory(0xf0, 7)
+
+	s := m.Snapshot()
+	regs := [4]uint64{}
+	for i := range regs {
+		regs[i], _ = m.GetRegister(uint64(i))
+	}
+	pc := m.GetCurrentInstructionAddress()
+
+	for range 16 {
+		_, _ = m.NextInstruction()
+	}
+	m.AdditionalState().(*ReduxKExtState).auxRegisters[1] = 0
+	_ = m.SetMemory(0xf0, 0)
+
+	err = m.Restore(s)
+	if err != nil {
+		t.Fatalf("Couldn't restore snapshot: %v", err)
+	}
+
+	for i, v := range regs {
+		r, _ := m.GetRegister(uint64(i))
+		if r != v {
+			t.Fatalf("Register %v not restored: expected %v, is %v", i, v, r)
+		}
+	}
+	if m.GetCurrentInstructionAddress() != pc {
+		t.Fatalf("PC not restored")
+	}
+	if mem, _ := m.GetMemory(0xf0); mem != 7 {
+		t.Fatalf("Memory not restored: %v", mem)
+	}
+	if m.AdditionalState().(*ReduxKExtState).auxRegisters[1] != 42 {
+		t.Fatalf("Auxiliary registers not restored")
+	}
+
+	// Malformed snapshots fail before the machine is reset.
+	outside := s
+	outside.Memory = append(slices.Clone(s.Memory), machine.MemoryChunk{Address: 0xff, Content: []uint8{1, 2}})
+	badPC := s
+	badPC.PC = 0x100
+	extra := s
+	extra.Extra = []uint64{0}
+	for _, bad := range []machine.Snapshot{outside, badPC, extra} {
+		if m.Restore(bad) == nil {
+			t.Fatalf("Restored malformed snapshot: %v", bad)
+		}
+		if mem, _ := m.GetMemory(0xf0); mem != 7 {
+			t.Fatalf("Malformed snapshot reset the machine")
+		}
+	}
+
+	s.Architecture = "riscv"
+	if m.Restore(s) == nil {
+		t.Fatalf("Restored snapshot from another architecture")
+	}
+}
+
+func TestJournal(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "addv.asm")
+	err := os.WriteFile(file, []byte("\taddv 1\n"), 0644)
+	if err != nil {
+		t.Fatalf("Couldn't write file: %v", err)
+	}
+
+	m := ReduxK()
+	code, _, err := m.Assemble(file)
+	if err != nil {
+		t.Fatalf("Couldn't assemble: %v", err)
+	}
+	_ = m.LoadProgram(code)
+
+	journal := machine.NewJournal(8)
+	m.SetObserver(journal)
+	journal.Begin(m.GetCurrentInstructionAddress())
+	_, _ = m.NextInstruction()
+	journal.End()
+
+	aux := &m.AdditionalState().(*ReduxKExtState).auxRegisters
+	if *aux == [2]uint8{} {
+		t.Fatalf("addv didn't write the auxiliary registers")
+	}
+	journal.Undo(m)
+	if *aux != [2]uint8{} {
+		t.Fatalf("Auxiliary registers not restored by undo: %v", *aux)
+	}
+}
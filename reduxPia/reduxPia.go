@@ -51,11 +51,15 @@ func reduxPiaExecuteExtension(bin uint8, m *reduxc.ReduxC) (bool, *machine.Call,
 			r0v, _ := m.GetRegister(0)
 			r1v, _ := m.GetRegister(1)
 			r2v, _ := m.GetRegister(2)
+			r3v, _ := m.GetRegister(3)
+			// Number in r0, arguments in r1, r2 and r3, return in r0.
 			*m.PC() = *m.PC() + 1
 			return true, &machine.Call{
 				Number: r0v,
 				Arg1:   r1v,
 				Arg2:   r2v,
+				Arg3:   r3v,
+				Return: 0,
 			}, nil
 		}
 	}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"slices"
 	"strconv"
 
 	"github.com/gboncoffee/egg/assembler"
@@ -22,6 +23,27 @@ type ExtensionExecutionFunction func(bin uint8, m *ReduxC) (bool, *machine.Call,
 
 type ExtensionAssembleFunction func(t assembler.ResolvedToken) (uint8, error)
 
+// Additional state of an extension that should return to it's initial value
+// when the machine is reset must implement this.
+type ResettableState interface {
+	Reset()
+}
+
+// Additional state of an extension that should be saved in snapshots must
+// implement this. Restore receives exactly what Snapshot returned.
+type SnapshotState interface {
+	Snapshot() []uint64
+	Restore([]uint64) error
+}
+
+// Additional state of an extension with registers besides r0-r3 must
+// implement this. They're numbered after r3 by GetRegister and SetRegister, so
+// their writes are seen by the observer (and thus can be undone).
+type RegisterState interface {
+	// The registers themselves, not a copy.
+	Registers() []uint8
+}
+
 type ReduxC struct {
 	mem               [math.MaxUint8 + 1]uint8
 	name              string
@@ -30,6 +52,7 @@ type ReduxC struct {
 	additionalState   any
 	registers         [4]uint8
 	pc                uint8
+	observer          machine.Observer
 }
 
 func ReduxVExtension(name string, e ExtensionExecutionFunction, a ExtensionAssembleFunction, additionalState any) *ReduxC {
@@ -59,6 +82,19 @@ func (m *ReduxC) GetCurrentInstructionAddress() uint64 {
 	return uint64(m.pc)
 }
 
+func (m *ReduxC) SetCurrentInstructionAddress(addr uint64) error {
+	if addr > math.MaxUint8 {
+		return fmt.Errorf(machine.InterCtx.Get("value %v is bigger than maximum 8 bit address %v"), addr, math.MaxUint8)
+	}
+
+	m.pc = uint8(addr)
+	return nil
+}
+
+func (m *ReduxC) SetObserver(o machine.Observer) {
+	m.observer = o
+}
+
 func (m *ReduxC) ArchitectureInfo() machine.ArchitectureInfo {
 	return machine.ArchitectureInfo{
 		Name:           m.name,
@@ -76,29 +112,51 @@ func (m *ReduxC) ArchitectureInfo() machine.ArchitectureInfo {
 // architecture?
 //
 
+// Returns r0-r3, or the registers of the additional state after them.
+func (m *ReduxC) register(reg uint64) (*uint8, error) {
+	if reg < uint64(len(m.registers)) {
+		return &m.registers[reg], nil
+	}
+	if state, ok := m.additionalState.(RegisterState); ok {
+		registers := state.Registers()
+		if reg-uint64(len(m.registers)) < uint64(len(registers)) {
+			return &registers[reg-uint64(len(m.registers))], nil
+		}
+	}
+	return nil, fmt.Errorf(machine.InterCtx.Get("no such register: %v"), reg)
+}
+
 func (m *ReduxC) SetRegister(reg uint64, value uint64) error {
-	if reg > 3 {
-		return fmt.Errorf(machine.InterCtx.Get("no such register: %v"), reg)
+	r, err := m.register(reg)
+	if err != nil {
+		return err
 	}
 	if value > math.MaxUint8 {
 		return fmt.Errorf(machine.InterCtx.Get("value %v is bigger than maximum 8 bit address %v"), value)
 	}
-	m.registers[reg] = uint8(value)
+	if m.observer != nil {
+		m.observer.OnRegisterWrite(reg, uint64(*r), value)
+	}
+	*r = uint8(value)
 	return nil
 }
 
 func (m *ReduxC) GetRegister(reg uint64) (uint64, error) {
-	if reg > 3 {
-		return 0, fmt.Errorf(machine.InterCtx.Get("no such register: %v"), reg)
+	r, err := m.register(reg)
+	if err != nil {
+		return 0, err
 	}
 
-	return uint64(m.registers[reg]), nil
+	return uint64(*r), nil
 }
 
 func (m *ReduxC) SetMemory(addr uint64, value uint8) error {
 	if addr > math.MaxUint8 {
 		return fmt.Errorf(machine.InterCtx.Get("value %v is bigger than maximum 8 bit address %v"), addr, math.MaxUint8)
 	}
+	if m.observer != nil {
+		m.observer.OnMemoryWrite(addr, m.mem[addr], value)
+	}
 	m.mem[addr] = value
 	return nil
 }
@@ -117,12 +175,24 @@ func (m *ReduxC) SetMemoryChunk(addr uint64, content []uint8) error {
 	}
 
 	for _, b := range content {
+		if m.observer != nil {
+			m.observer.OnMemoryWrite(addr, m.mem[addr], b)
+		}
 		m.mem[addr] = b
 		addr++
 	}
 	return nil
 }
 
+// Same as GetMemory, but notifies the observer of the read. Should be used by
+// every instruction that reads memory, including extensions.
+func (m *ReduxC) LoadMemory(addr uint64) (uint8, error) {
+	if m.observer != nil {
+		m.observer.OnMemoryRead(addr, 1)
+	}
+	return m.GetMemory(addr)
+}
+
 func (m *ReduxC) GetMemoryChunk(addr uint64, size uint64) ([]uint8, error) {
 	end := addr + (size - 1)
 	if end > math.MaxUint8 {
@@ -131,6 +201,68 @@ func (m *ReduxC) GetMemoryChunk(addr uint64, size uint64) ([]uint8, error) {
 	return m.mem[addr:(end + 1)], nil
 }
 
+func (m *ReduxC) Reset() {
+	m.mem = [math.MaxUint8 + 1]uint8{}
+	m.registers = [4]uint8{}
+	m.pc = 0
+	if state, ok := m.additionalState.(ResettableState); ok {
+		state.Reset()
+	}
+}
+
+func (m *ReduxC) Snapshot() machine.Snapshot {
+	s := machine.Snapshot{
+		Architecture: m.name,
+		Registers:    make([]uint64, len(m.registers)),
+		PC:           uint64(m.pc),
+		Extra:        []uint64{},
+		Memory: []machine.MemoryChunk{{
+			Address: 0,
+			Content: slices.Clone(m.mem[:]),
+		}},
+	}
+	for i, v := range m.registers {
+		s.Registers[i] = uint64(v)
+	}
+	if state, ok := m.additionalState.(SnapshotState); ok {
+		s.Extra = state.Snapshot()
+	}
+
+	return s
+}
+
+func (m *ReduxC) Restore(s machine.Snapshot) error {
+	state, ok := m.additionalState.(SnapshotState)
+	extra := 0
+	if ok {
+		extra = len(state.Snapshot())
+	}
+	err := machine.CheckSnapshot(s, m.name, len(m.registers), extra, math.MaxUint8)
+	if err != nil {
+		return err
+	}
+
+	m.Reset()
+	if ok {
+		err := state.Restore(s.Extra)
+		if err != nil {
+			return err
+		}
+	}
+	for i, v := range s.Registers {
+		m.registers[i] = uint8(v)
+	}
+	m.pc = uint8(s.PC)
+	for _, chunk := range s.Memory {
+		err := m.SetMemoryChunk(chunk.Address, chunk.Content)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (m *ReduxC) LoadProgram(program []uint8) error {
 	m.pc = 0
 	return m.SetMemoryChunk(0, program)
@@ -168,7 +300,7 @@ func (m *ReduxC) NextInstruction() (*machine.Call, error) {
 	case 0x1:
 		m.pc += signExtend8(uint8(imm)) - 1
 	case 0x2:
-		mem, _ := m.GetMemory(rbv)
+		mem, _ := m.LoadMemory(rbv)
 		_ = m.SetRegister(uint64(ra), uint64(mem))
 	case 0x3:
 		_ = m.SetMemory(rbv, uint8(rav))
@@ -221,6 +353,14 @@ func (m *ReduxC) GetRegisterNumber(r string) (uint64, error) {
 }
 
 // Also heavily-based on Sagui.
+// Immediates have 4 bits. Labels are not checked, as jumps use them.
+func checkImmediate(arg string, value uint64) error {
+	if int64(value) > 0xf {
+		return fmt.Errorf(machine.InterCtx.Get("immediate bigger than immediate size: %v"), arg)
+	}
+	return nil
+}
+
 func translateArgs(arg string) (uint64, error) {
 	if len(arg) < 1 {
 		return 0, errors.New(machine.InterCtx.Get("empty argument"))
@@ -228,9 +368,6 @@ func translateArgs(arg string) (uint64, error) {
 	reg, err := getRegisterNumber(arg)
 	if err != nil {
 		n, err := strconv.ParseInt(arg, 0, 64)
-		if n > 0xf {
-			return 0, fmt.Errorf(machine.InterCtx.Get("immediate bigger than immediate size: %v"), arg)
-		}
 		return uint64(n), err
 	}
 	return reg, nil
@@ -367,7 +504,8 @@ func (m *ReduxC) Assemble(file string) ([]uint8, []assembler.DebuggerToken, erro
 		return nil, nil, err
 	}
 
-	resolvedTokens, debuggerTokens, err := assembler.ResolveTokens(tokens, func(i *assembler.Instruction) error {
+	options := assembler.ResolveOptions{MaxAddress: math.MaxUint8, CheckArgument: checkImmediate}
+	program, err := assembler.ResolveProgram(tokens, options, func(i *assembler.Instruction) error {
 		i.Size = 1
 		return nil
 	}, translateArgs)
@@ -376,10 +514,10 @@ func (m *ReduxC) Assemble(file string) ([]uint8, []assembler.DebuggerToken, erro
 		return nil, nil, err
 	}
 
-	code, err := m.assemble(resolvedTokens)
+	code, err := m.assemble(program.Text)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	return code, debuggerTokens, nil
+	return code, program.DebuggerTokens, nil
 }
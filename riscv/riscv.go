@@ -2,6 +2,7 @@
 package riscv
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"math"
@@ -16,6 +17,19 @@ type RiscV struct {
 	registers [32]uint32
 	pc        uint32
 	mem       [math.MaxUint32 + 1]uint8
+	dirty     machine.DirtyPages
+	observer  machine.Observer
+	// Number calls as RARS.
+	rars bool
+	// Memory layout set by the user, or nil for the default one.
+	layout *machine.MemoryLayout
+	// Where the assembled program is loaded and started, it's .data
+	// section and the address after it's end.
+	textBase uint32
+	dataBase uint32
+	data     []uint8
+	entry    uint32
+	end      uint32
 }
 
 // Sign extends the number n which has s bits. I hope gc inlines this function
@@ -275,6 +289,11 @@ func (m *RiscV) execLoad(rd uint8, rs1 uint8, imm uint32, func3 uint8) {
 	addr := uint64(addr32)
 	var v uint32
 
+	if m.observer != nil {
+		// The lower two bits of func3 are the log2 of the access size.
+		m.observer.OnMemoryRead(addr, 1<<(func3&0x3))
+	}
+
 	switch func3 {
 	case 0x0:
 		mem, _ := m.GetMemory(addr)
@@ -435,10 +454,14 @@ func (m *RiscV) execute(i uint32) (*machine.Call, error) {
 		num, _ := m.GetRegister(17)
 		a1, _ := m.GetRegister(10)
 		a2, _ := m.GetRegister(11)
+		a3, _ := m.GetRegister(12)
 		if imm != 0 {
 			num = machine.SYS_BREAK
+		} else if m.rars {
+			return m.rarsCall()
 		}
-		call := machine.Call{Number: num, Arg1: a1, Arg2: a2}
+		// Number in a7, arguments in a0, a1 and a2, return in a0.
+		call := machine.Call{Number: num, Arg1: a1, Arg2: a2, Arg3: a3, Return: 10}
 		m.pc += 4
 
 		return &call, nil
@@ -449,9 +472,162 @@ func (m *RiscV) execute(i uint32) (*machine.Call, error) {
 	return nil, err
 }
 
-func (m *RiscV) LoadProgram(program []uint8) error {
+// Translates an ecall numbered as in RARS to the EGG call. The number is in
+// a7, the arguments in a0, a1 and a2, and the return in a0.
+func (m *RiscV) rarsCall() (*machine.Call, error) {
+	num := m.registers[17]
+	a0 := uint64(m.registers[10])
+	a1 := uint64(m.registers[11])
+	a2 := uint64(m.registers[12])
+
+	call := machine.Call{Return: 10}
+	switch num {
+	case 1: // PrintInt
+		call.Number, call.Arg1 = machine.SYS_PRINT_INT, a0
+	case 4: // PrintString
+		call.Number, call.Arg1 = machine.SYS_PRINT_STRING, a0
+	case 5: // ReadInt
+		call.Number = machine.SYS_READ_INT
+	case 8: // ReadString
+		call.Number, call.Arg1, call.Arg2 = machine.SYS_READ_STRING, a0, a1
+	case 9: // Sbrk
+		call.Number, call.Arg1 = machine.SYS_SBRK, a0
+	case 10: // Exit
+		call.Number = machine.SYS_EXIT
+	case 11: // PrintChar
+		call.Number, call.Arg1 = machine.SYS_PRINT_CHAR, a0
+	case 12: // ReadChar
+		call.Number = machine.SYS_READ_CHAR
+	case 93: // Exit2 (17 is GetCWD, not Exit2 as in MARS)
+		call.Number, call.Arg1 = machine.SYS_EXIT, a0
+	case 30: // Time (only the lower word)
+		call.Number = machine.SYS_TIME
+	case 36: // PrintIntUnsigned
+		call.Number, call.Arg1 = machine.SYS_PRINT_UINT, a0
+	case 41: // RandInt
+		call.Number = machine.SYS_RANDOM
+	case 57: // Close
+		call.Number, call.Arg1 = machine.SYS_CLOSE, a0
+	case 62: // LSeek
+		call.Number, call.Arg1, call.Arg2, call.Arg3 = machine.SYS_LSEEK, a0, a1, a2
+	case 63: // Read
+		call.Number, call.Arg1, call.Arg2, call.Arg3 = machine.SYS_READ_FD, a0, a1, a2
+	case 64: // Write
+		call.Number, call.Arg1, call.Arg2, call.Arg3 = machine.SYS_WRITE_FD, a0, a1, a2
+	case 1024: // Open
+		call.Number, call.Arg1, call.Arg2 = machine.SYS_OPEN, a0, a1
+	default:
+		return nil, fmt.Errorf(machine.InterCtx.Get("unsupported RARS call: %v"), num)
+	}
+	m.pc += 4
+
+	return &call, nil
+}
+
+// Sets argc, argv and envp in a0, a1 and a2, with the stack pointer just below
+// them.
+func (m *RiscV) SetArguments(args []string, env []string) error {
+	sp, argv, envp, err := machine.LayoutArguments(m, m.Layout().StackTop, 4, binary.LittleEndian, args, env)
+	if err != nil {
+		return err
+	}
+
+	_ = m.SetRegister(2, sp)
+	_ = m.SetRegister(10, uint64(len(args)))
+	_ = m.SetRegister(11, argv)
+	_ = m.SetRegister(12, envp)
+	return nil
+}
+
+func (m *RiscV) Layout() machine.MemoryLayout {
+	if m.layout == nil {
+		return machine.DefaultLayout32
+	}
+	return *m.layout
+}
+
+func (m *RiscV) SetLayout(l machine.MemoryLayout) error {
+	err := machine.CheckLayout32(l)
+	if err != nil {
+		return err
+	}
+	m.layout = &l
+	return nil
+}
+
+func (m *RiscV) ProgramEnd() uint64 {
+	return uint64(m.end)
+}
+
+func (m *RiscV) SetSyscalls(numbering string) error {
+	switch numbering {
+	case machine.SYSCALLS_EGG:
+		m.rars = false
+	case machine.SYSCALLS_RARS:
+		m.rars = true
+	default:
+		return fmt.Errorf(machine.InterCtx.Get("unsupported call numbering: %v"), numbering)
+	}
+	return nil
+}
+
+func (m *RiscV) Reset() {
+	m.dirty.Each(func(page uint64) {
+		clear(m.mem[page : page+machine.PageSize])
+	})
+	m.dirty.Clear()
+	m.registers = [32]uint32{}
 	m.pc = 0
-	return m.SetMemoryChunk(0, program)
+}
+
+func (m *RiscV) Snapshot() machine.Snapshot {
+	s := machine.Snapshot{
+		Architecture: m.ArchitectureInfo().Name,
+		Registers:    make([]uint64, 32),
+		PC:           uint64(m.pc),
+		Memory:       m.dirty.Copy(m.mem[:]),
+	}
+	for i := range s.Registers {
+		s.Registers[i] = uint64(m.registers[i])
+	}
+
+	return s
+}
+
+func (m *RiscV) Restore(s machine.Snapshot) error {
+	err := machine.CheckSnapshot(s, m.ArchitectureInfo().Name, 32, 0, math.MaxUint32)
+	if err != nil {
+		return err
+	}
+
+	m.Reset()
+	for i, v := range s.Registers {
+		m.registers[i] = uint32(v)
+	}
+	m.pc = uint32(s.PC)
+	for _, chunk := range s.Memory {
+		err := m.SetMemoryChunk(chunk.Address, chunk.Content)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Loads the program at the base of it's text, and it's data, if any, at the
+// base of the data. Starts it at it's entry point, with the stack and global
+// pointers set as in the memory layout.
+func (m *RiscV) LoadProgram(program []uint8) error {
+	layout := m.Layout()
+	m.pc = m.entry
+	_ = m.SetRegister(2, layout.StackTop)
+	_ = m.SetRegister(3, layout.GlobalPointer)
+	err := m.SetMemoryChunk(uint64(m.textBase), program)
+	if err != nil || len(m.data) == 0 {
+		return err
+	}
+	return m.SetMemoryChunk(uint64(m.dataBase), m.data)
 }
 
 func (m *RiscV) NextInstruction() (*machine.Call, error) {
@@ -478,7 +654,11 @@ func (m *RiscV) SetMemory(addr uint64, content uint8) error {
 		return fmt.Errorf(machine.InterCtx.Get("value %v bigger than maximum 32 bit address %v"), addr, math.MaxUint32)
 	}
 
+	if m.observer != nil {
+		m.observer.OnMemoryWrite(addr, m.mem[addr], content)
+	}
 	m.mem[addr] = content
+	m.dirty.Mark(addr, 1)
 
 	return nil
 }
@@ -498,7 +678,11 @@ func (m *RiscV) SetMemoryChunk(addr uint64, content []uint8) error {
 		return fmt.Errorf(machine.InterCtx.Get("end address %v bigger than maximum 32 bit address %v"), end, math.MaxUint32)
 	}
 
+	m.dirty.Mark(addr, uint64(len(content)))
 	for _, b := range content {
+		if m.observer != nil {
+			m.observer.OnMemoryWrite(addr, m.mem[addr], b)
+		}
 		m.mem[addr] = b
 		addr++
 	}
@@ -520,6 +704,9 @@ func (m *RiscV) SetRegister(reg uint64, content uint64) error {
 	}
 
 	if reg != 0 {
+		if m.observer != nil {
+			m.observer.OnRegisterWrite(reg, uint64(m.registers[reg]), uint64(uint32(content)))
+		}
 		m.registers[reg] = uint32(content) // Overflow is a feature.
 	}
 
@@ -784,9 +971,9 @@ func assembleInstruction(code []uint8, addr int, t assembler.ResolvedToken) erro
 	case "sb", "sh", "sw":
 		bin, err = assembleStore(t)
 	case "beq", "bne", "blt", "bge", "bltu", "bgeu":
-		bin, err = assembleBranch(t, addr)
+		bin, err = assembleBranch(t, int(t.Address))
 	case "jal":
-		bin, err = assembleJal(t, addr)
+		bin, err = assembleJal(t, int(t.Address))
 	case "jalr":
 		bin, err = assembleJalr(t)
 	case "lui", "auipc":
@@ -918,7 +1105,16 @@ func (m *RiscV) Assemble(file string) ([]uint8, []assembler.DebuggerToken, error
 		return nil, nil, err
 	}
 
-	resolvedTokens, debuggerTokens, err := assembler.ResolveTokens(tokens, func(i *assembler.Instruction) error {
+	layout := m.Layout()
+	options := assembler.ResolveOptions{
+		Base:      layout.FlatBase,
+		Sections:  true,
+		TextBase:  layout.TextBase,
+		DataBase:  layout.DataBase,
+		Entry:     layout.Entry,
+		Functions: assembler.HiLo(12),
+	}
+	program, err := assembler.ResolveProgram(tokens, options, func(i *assembler.Instruction) error {
 		i.Size = 4
 		return nil
 	}, translateArgs)
@@ -927,18 +1123,41 @@ func (m *RiscV) Assemble(file string) ([]uint8, []assembler.DebuggerToken, error
 		return nil, nil, err
 	}
 
-	code, err := assemble(resolvedTokens)
+	code, err := assemble(program.Text)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := assemble(program.Data)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	return code, debuggerTokens, nil
+	m.textBase = uint32(program.TextBase)
+	m.dataBase = uint32(program.DataBase)
+	m.data = data
+	m.entry = uint32(program.Entry)
+	m.end = uint32(program.End)
+
+	return code, program.DebuggerTokens, nil
 }
 
 func (m *RiscV) GetCurrentInstructionAddress() uint64 {
 	return uint64(m.pc)
 }
 
+func (m *RiscV) SetCurrentInstructionAddress(addr uint64) error {
+	if addr > math.MaxUint32 {
+		return fmt.Errorf(machine.InterCtx.Get("value %v bigger than maximum 32 bit address %v"), addr, math.MaxUint32)
+	}
+
+	m.pc = uint32(addr)
+	return nil
+}
+
+func (m *RiscV) SetObserver(o machine.Observer) {
+	m.observer = o
+}
+
 func (m *RiscV) ArchitectureInfo() machine.ArchitectureInfo {
 	var info machine.ArchitectureInfo
 	info.Name = "RISC-V IM (32 bits)"
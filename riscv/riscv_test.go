@@ -1,11 +1,15 @@
 package riscv
 
 import (
+	"encoding/binary"
 	"math"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 
 	"github.com/gboncoffee/egg/assembler"
+	"github.com/gboncoffee/egg/machine"
 	"github.com/gboncoffee/intergo"
 )
 
@@ -445,3 +449,113 @@ func TestInstructions(t *testing.T) {
 	_, _ = m.NextInstruction()
 	m.assertRegister(t, "t2", uint64(0x7FFFF000), "remu t2, t0, t1")
 }
+
+func TestRarsCalls(t *testing.T) {
+	var m RiscV
+	err := m.SetSyscalls(machine.SYSCALLS_RARS)
+	if err != nil {
+		t.Fatalf("Error setting RARS calls: %v", err)
+	}
+
+	// ecall.
+	ecall := []uint8{0x73, 0, 0, 0}
+	tests := []struct {
+		a7       uint64
+		expected machine.Call
+	}{
+		{1, machine.Call{Number: machine.SYS_PRINT_INT, Arg1: 1, Return: 10}},
+		{8, machine.Call{Number: machine.SYS_READ_STRING, Arg1: 1, Arg2: 2, Return: 10}},
+		{10, machine.Call{Number: machine.SYS_EXIT, Return: 10}},
+		{93, machine.Call{Number: machine.SYS_EXIT, Arg1: 1, Return: 10}},
+		{64, machine.Call{Number: machine.SYS_WRITE_FD, Arg1: 1, Arg2: 2, Arg3: 3, Return: 10}},
+	}
+	for _, test := range tests {
+		m.Reset()
+		_ = m.LoadProgram(ecall)
+		_ = m.SetRegister(17, test.a7)
+		_ = m.SetRegister(10, 1)
+		_ = m.SetRegister(11, 2)
+		_ = m.SetRegister(12, 3)
+		call, err := m.NextInstruction()
+		if err != nil || *call != test.expected {
+			t.Fatalf("Wrong call for RARS %v: %v, %v", test.a7, call, err)
+		}
+	}
+
+	// 17 is GetCWD.
+	for _, a7 := range []uint64{17, 1000} {
+		m.Reset()
+		_ = m.LoadProgram(ecall)
+		_ = m.SetRegister(17, a7)
+		_, err = m.NextInstruction()
+		if err == nil {
+			t.Fatalf("No error for unsupported RARS call %v", a7)
+		}
+	}
+}
+
+func TestArguments(t *testing.T) {
+	var m RiscV
+	err := m.SetArguments([]string{"foo", "ba"}, []string{"X=1"})
+	if err != nil {
+		t.Fatalf("Error setting arguments: %v", err)
+	}
+
+	sp, _ := m.GetRegister(2)
+	argc, _ := m.GetRegister(10)
+	argv, _ := m.GetRegister(11)
+	envp, _ := m.GetRegister(12)
+	if sp%16 != 0 || argc != 2 || argv != sp+4 || envp != sp+16 {
+		t.Fatalf("Wrong registers: sp 0x%x, a0 %v, a1 0x%x, a2 0x%x", sp, argc, argv, envp)
+	}
+
+	words, _ := m.GetMemoryChunk(sp, 24)
+	strs, _ := m.GetMemoryChunk(machine.STACK_TOP_32-11, 11)
+	expected := []uint8("foo\x00ba\x00X=1\x00")
+	if !reflect.DeepEqual(strs, expected) {
+		t.Fatalf("Wrong strings: %q (expected %q)", strs, expected)
+	}
+	pointers := []uint32{2, machine.STACK_TOP_32 - 11, machine.STACK_TOP_32 - 7, 0, machine.STACK_TOP_32 - 4, 0}
+	for i, p := range pointers {
+		if v := binary.LittleEndian.Uint32(words[i*4:]); v != p {
+			t.Fatalf("Wrong word %v: 0x%x (expected 0x%x)", i, v, p)
+		}
+	}
+}
+
+func TestLayout(t *testing.T) {
+	var m RiscV
+	err := m.SetLayout(machine.MemoryLayout{FlatBase: 0x400000, StackTop: 0x1000, GlobalPointer: 0x2000, Entry: "main"})
+	if err != nil {
+		t.Fatalf("Error setting layout: %v", err)
+	}
+	if m.SetLayout(machine.MemoryLayout{DataBase: 2}) == nil {
+		t.Fatalf("Misaligned data base accepted")
+	}
+
+	file := filepath.Join(t.TempDir(), "entry.asm")
+	err = os.WriteFile(file, []byte("ecall\nmain:\njal zero, main\n"), 0644)
+	if err != nil {
+		t.Fatalf("Error writing file: %v", err)
+	}
+	code, _, err := m.Assemble(file)
+	if err != nil {
+		t.Fatalf("Couldn't assemble: %v", err)
+	}
+	err = m.LoadProgram(code)
+	if err != nil {
+		t.Fatalf("Couldn't load: %v", err)
+	}
+
+	sp, _ := m.GetRegister(2)
+	gp, _ := m.GetRegister(3)
+	if m.GetCurrentInstructionAddress() != 0x400004 || sp != 0x1000 || gp != 0x2000 {
+		t.Fatalf("Wrong initial state: pc 0x%x, sp 0x%x, gp 0x%x", m.GetCurrentInstructionAddress(), sp, gp)
+	}
+
+	// The jump is relative, so it must still jump to itself.
+	_, err = m.NextInstruction()
+	if err != nil || m.GetCurrentInstructionAddress() != 0x400004 {
+		t.Fatalf("Wrong jump: pc 0x%x, %v", m.GetCurrentInstructionAddress(), err)
+	}
+}
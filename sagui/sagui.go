@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"slices"
 	"strconv"
 
 	"github.com/gboncoffee/egg/assembler"
@@ -17,6 +18,7 @@ type Sagui struct {
 	registers [4]uint8
 	pc        uint8
 	mem       [math.MaxUint8 + 1]uint8
+	observer  machine.Observer
 }
 
 func signExtend(n uint8) uint8 {
@@ -38,6 +40,9 @@ func (m *Sagui) SetRegister(reg uint64, value uint64) error {
 	if value > math.MaxUint8 {
 		return fmt.Errorf(machine.InterCtx.Get("value %v is bigger than maximum 8 bit address %v"), value)
 	}
+	if m.observer != nil {
+		m.observer.OnRegisterWrite(reg, uint64(m.registers[reg]), value)
+	}
 	m.registers[reg] = uint8(value)
 	return nil
 }
@@ -61,6 +66,9 @@ func (m *Sagui) SetMemory(addr uint64, value uint8) error {
 	if addr > math.MaxUint8 {
 		return fmt.Errorf(machine.InterCtx.Get("value %v is bigger than maximum 8 bit address %v"), addr, math.MaxUint8)
 	}
+	if m.observer != nil {
+		m.observer.OnMemoryWrite(addr, m.mem[addr], value)
+	}
 	m.mem[addr] = value
 	return nil
 }
@@ -80,12 +88,59 @@ func (m *Sagui) SetMemoryChunk(addr uint64, content []uint8) error {
 	}
 
 	for _, b := range content {
+		if m.observer != nil {
+			m.observer.OnMemoryWrite(addr, m.mem[addr], b)
+		}
 		m.mem[addr] = b
 		addr++
 	}
 	return nil
 }
 
+func (m *Sagui) Reset() {
+	m.registers = [4]uint8{}
+	m.pc = 0
+	m.mem = [math.MaxUint8 + 1]uint8{}
+}
+
+func (m *Sagui) Snapshot() machine.Snapshot {
+	s := machine.Snapshot{
+		Architecture: m.ArchitectureInfo().Name,
+		Registers:    make([]uint64, len(m.registers)),
+		PC:           uint64(m.pc),
+		Memory: []machine.MemoryChunk{{
+			Address: 0,
+			Content: slices.Clone(m.mem[:]),
+		}},
+	}
+	for i, v := range m.registers {
+		s.Registers[i] = uint64(v)
+	}
+
+	return s
+}
+
+func (m *Sagui) Restore(s machine.Snapshot) error {
+	err := machine.CheckSnapshot(s, m.ArchitectureInfo().Name, len(m.registers), 0, math.MaxUint8)
+	if err != nil {
+		return err
+	}
+
+	m.Reset()
+	for i, v := range s.Registers {
+		m.registers[i] = uint8(v)
+	}
+	m.pc = uint8(s.PC)
+	for _, chunk := range s.Memory {
+		err := m.SetMemoryChunk(chunk.Address, chunk.Content)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (m *Sagui) LoadProgram(program []uint8) error {
 	m.pc = 0
 	return m.SetMemoryChunk(0, program)
@@ -95,6 +150,19 @@ func (m *Sagui) GetCurrentInstructionAddress() uint64 {
 	return uint64(m.pc)
 }
 
+func (m *Sagui) SetCurrentInstructionAddress(addr uint64) error {
+	if addr > math.MaxUint8 {
+		return fmt.Errorf(machine.InterCtx.Get("value %v is bigger than maximum 8 bit address %v"), addr, math.MaxUint8)
+	}
+
+	m.pc = uint8(addr)
+	return nil
+}
+
+func (m *Sagui) SetObserver(o machine.Observer) {
+	m.observer = o
+}
+
 func (m *Sagui) NextInstruction() (*machine.Call, error) {
 	instr, err := m.GetMemory(m.GetCurrentInstructionAddress())
 	if err != nil {
@@ -133,6 +201,9 @@ func (m *Sagui) NextInstruction() (*machine.Call, error) {
 	case 0x3:
 		m.pc = m.pc + signExtend(uint8(imm)) - 1
 	case 0x4:
+		if m.observer != nil {
+			m.observer.OnMemoryRead(rbv, 1)
+		}
 		mem, _ := m.GetMemory(rbv)
 		_ = m.SetRegister(uint64(ra), uint64(mem))
 	case 0x5:
@@ -200,6 +271,14 @@ func (m *Sagui) GetRegisterNumber(r string) (uint64, error) {
 	return getRegisterNumber(r)
 }
 
+// Immediates have 4 bits. Labels are not checked, as jumps use them.
+func checkImmediate(arg string, value uint64) error {
+	if int64(value) > 0xf {
+		return fmt.Errorf(machine.InterCtx.Get("immediate bigger than immediate size: %v"), arg)
+	}
+	return nil
+}
+
 func translateArgs(arg string) (uint64, error) {
 	if len(arg) < 1 {
 		return 0, errors.New(machine.InterCtx.Get("empty argument"))
@@ -207,9 +286,6 @@ func translateArgs(arg string) (uint64, error) {
 	reg, err := getRegisterNumber(arg)
 	if err != nil {
 		n, err := strconv.ParseInt(arg, 0, 64)
-		if n > 0xf {
-			return 0, fmt.Errorf(machine.InterCtx.Get("immediate bigger than immediate size: %v"), arg)
-		}
 		return uint64(n), err
 	}
 	return reg, nil
@@ -360,7 +436,8 @@ func (m *Sagui) Assemble(file string) ([]uint8, []assembler.DebuggerToken, error
 		return nil, nil, err
 	}
 
-	resolvedTokens, debuggerTokens, err := assembler.ResolveTokens(tokens, func(i *assembler.Instruction) error {
+	options := assembler.ResolveOptions{MaxAddress: math.MaxUint8, CheckArgument: checkImmediate}
+	program, err := assembler.ResolveProgram(tokens, options, func(i *assembler.Instruction) error {
 		i.Size = 1
 		return nil
 	}, translateArgs)
@@ -369,10 +446,10 @@ func (m *Sagui) Assemble(file string) ([]uint8, []assembler.DebuggerToken, error
 		return nil, nil, err
 	}
 
-	code, err := assemble(resolvedTokens)
+	code, err := assemble(program.Text)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	return code, debuggerTokens, nil
+	return code, program.DebuggerTokens, nil
 }
@@ -1,8 +1,13 @@
 package sagui
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
 	"testing"
 
+	"github.com/gboncoffee/egg/assembler"
 	"github.com/gboncoffee/egg/machine"
 )
 
@@ -110,3 +115,107 @@ func TestSagui(t *testing.T) {
 		t.Fatalf("Break failed: %v", call)
 	}
 }
+
+func TestJournal(t *testing.T) {
+	var m Sagui
+	code, _, err := m.Assemble("test.asm")
+	if err != nil {
+		t.Fatalf("Couldn't assemble: %v", err)
+	}
+
+	_ = m.LoadProgram(code)
+	before := m.Snapshot()
+
+	journal := machine.NewJournal(64)
+	m.SetObserver(journal)
+
+	for range 32 {
+		journal.Begin(m.GetCurrentInstructionAddress())
+		_, _ = m.NextInstruction()
+		journal.End()
+	}
+	if journal.Steps() != 32 {
+		t.Fatalf("Journal has %v steps, expected 32", journal.Steps())
+	}
+
+	for journal.Undo(&m) {
+	}
+
+	after := m.Snapshot()
+	if after.PC != before.PC {
+		t.Fatalf("PC not restored by undo: 0x%x (expected 0x%x)", after.PC, before.PC)
+	}
+	if !slices.Equal(after.Registers, before.Registers) {
+		t.Fatalf("Registers not restored by undo: %v (expected %v)", after.Registers, before.Registers)
+	}
+	if !slices.Equal(after.Memory[0].Content, before.Memory[0].Content) {
+		t.Fatalf("Memory not restored by undo")
+	}
+
+	journal = machine.NewJournal(8)
+	m.SetObserver(journal)
+	for range 32 {
+		journal.Begin(m.GetCurrentInstructionAddress())
+		_, _ = m.NextInstruction()
+		journal.End()
+	}
+	if journal.Steps() > 8 {
+		t.Fatalf("Journal has %v steps, more than it's limit", journal.Steps())
+	}
+}
+
+type recorder struct {
+	events []string
+}
+
+func (r *recorder) OnMemoryRead(addr uint64, size uint64) {
+	r.events = append(r.events, fmt.Sprintf("read 0x%x@%v", addr, size))
+}
+
+func (r *recorder) OnMemoryWrite(addr uint64, old uint8, new uint8) {
+	r.events = append(r.events, fmt.Sprintf("write 0x%x: %v -> %v", addr, old, new))
+}
+
+func (r *recorder) OnRegisterWrite(reg uint64, old uint64, new uint64) {
+	r.events = append(r.events, fmt.Sprintf("register %v: %v -> %v", reg, old, new))
+}
+
+func TestObserver(t *testing.T) {
+	var m Sagui
+	// st r0, r1; ld r1, r2
+	_ = m.LoadProgram([]uint8{0x51, 0x46})
+	_ = m.SetRegister(0, 7)
+	_ = m.SetRegister(1, 0x80)
+	_ = m.SetRegister(2, 0x80)
+
+	var r recorder
+	m.SetObserver(&r)
+	_, _ = m.NextInstruction()
+	_, _ = m.NextInstruction()
+
+	expected := []string{
+		"write 0x80: 0 -> 7",
+		"read 0x80@1",
+		"register 1: 128 -> 7",
+	}
+	if !slices.Equal(r.events, expected) {
+		t.Fatalf("Wrong events: %v (expected %v)", r.events, expected)
+	}
+}
+
+func TestImmediates(t *testing.T) {
+	machine.InterCtx.Init()
+	assembler.InterCtx = &machine.InterCtx
+
+	file := filepath.Join(t.TempDir(), "immediates.asm")
+	err := os.WriteFile(file, []byte("movl 4+4\nmovl 8+8\n"), 0644)
+	if err != nil {
+		t.Fatalf("Error writing file: %v", err)
+	}
+
+	var m Sagui
+	_, _, err = m.Assemble(file)
+	if err == nil {
+		t.Fatalf("Expression bigger than immediate size accepted")
+	}
+}